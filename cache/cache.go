@@ -0,0 +1,22 @@
+package cache
+
+// 按字节数限制容量的缓存，超过容量时淘汰最久没有使用的数据，可以被多个goroutine同时使用
+type Cache interface {
+	// 加入缓存，charge是value占用的容量，key已经存在时替换
+	Insert(key []byte, value interface{}, charge int)
+
+	// 查找缓存，同时计入命中或者未命中次数
+	Lookup(key []byte) (interface{}, bool)
+
+	Erase(key []byte)
+
+	// 返回一个新的id，多个使用者共享缓存时用id区分各自的key
+	NewId() uint64
+
+	// 当前所有数据的charge之和
+	TotalCharge() int
+
+	// Lookup命中和未命中的次数
+	Hits() uint64
+	Misses() uint64
+}
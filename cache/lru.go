@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// 分成多个shard减少锁冲突，每个shard单独做lru
+const (
+	numShardBits = 4
+	numShards    = 1 << numShardBits
+)
+
+type lruCache struct {
+	// 64位原子操作的字段放在最前面，保证32位平台上8字节对齐
+	lastId uint64
+	hits   uint64
+	misses uint64
+	shards [numShards]lruShard
+}
+
+type lruShard struct {
+	mu       sync.Mutex
+	capacity int
+	usage    int
+	lru      *list.List // 最近使用的在前面
+	table    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key    string
+	value  interface{}
+	charge int
+}
+
+// capacity是所有shard的容量之和，为0时不缓存任何数据
+func NewLRUCache(capacity int) Cache {
+	var c lruCache
+	perShard := (capacity + numShards - 1) / numShards
+	for i := range c.shards {
+		c.shards[i].capacity = perShard
+		c.shards[i].lru = list.New()
+		c.shards[i].table = make(map[string]*list.Element)
+	}
+	return &c
+}
+
+func (c *lruCache) shard(key []byte) *lruShard {
+	h := fnv.New32a()
+	h.Write(key)
+	return &c.shards[h.Sum32()>>(32-numShardBits)]
+}
+
+func (c *lruCache) Insert(key []byte, value interface{}, charge int) {
+	c.shard(key).insert(string(key), value, charge)
+}
+
+func (c *lruCache) Lookup(key []byte) (interface{}, bool) {
+	value, ok := c.shard(key).lookup(string(key))
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return value, ok
+}
+
+func (c *lruCache) Erase(key []byte) {
+	c.shard(key).erase(string(key))
+}
+
+func (c *lruCache) NewId() uint64 {
+	return atomic.AddUint64(&c.lastId, 1)
+}
+
+func (c *lruCache) TotalCharge() int {
+	total := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		total += s.usage
+		s.mu.Unlock()
+	}
+	return total
+}
+
+func (c *lruCache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+func (c *lruCache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+func (s *lruShard) insert(key string, value interface{}, charge int) {
+	if s.capacity == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.table[key]; ok {
+		s.remove(e)
+	}
+	s.table[key] = s.lru.PushFront(&lruEntry{key: key, value: value, charge: charge})
+	s.usage += charge
+	// 刚加入的数据即使超过容量也保留，否则马上Lookup会失败
+	for s.usage > s.capacity && s.lru.Len() > 1 {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *lruShard) lookup(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.table[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (s *lruShard) erase(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.table[key]; ok {
+		s.remove(e)
+	}
+}
+
+// REQUIRES: s.mu held
+func (s *lruShard) remove(e *list.Element) {
+	entry := s.lru.Remove(e).(*lruEntry)
+	delete(s.table, entry.key)
+	s.usage -= entry.charge
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func encodeKey(k int) []byte {
+	p := make([]byte, 4)
+	binary.LittleEndian.PutUint32(p, uint32(k))
+	return p
+}
+
+func lookup(c Cache, k int) int {
+	value, ok := c.Lookup(encodeKey(k))
+	if !ok {
+		return -1
+	}
+	return value.(int)
+}
+
+func Test_LRUCache_HitAndMiss(t *testing.T) {
+	c := NewLRUCache(1000 * numShards)
+	if lookup(c, 100) != -1 {
+		t.Fatal("empty cache hit")
+	}
+
+	c.Insert(encodeKey(100), 101, 1)
+	if lookup(c, 100) != 101 || lookup(c, 200) != -1 || lookup(c, 300) != -1 {
+		t.Fatal("unexpected lookup after first insert")
+	}
+
+	c.Insert(encodeKey(200), 201, 1)
+	if lookup(c, 100) != 101 || lookup(c, 200) != 201 || lookup(c, 300) != -1 {
+		t.Fatal("unexpected lookup after second insert")
+	}
+
+	c.Insert(encodeKey(100), 102, 1)
+	if lookup(c, 100) != 102 || lookup(c, 200) != 201 || lookup(c, 300) != -1 {
+		t.Fatal("unexpected lookup after replace")
+	}
+	if c.TotalCharge() != 2 {
+		t.Fatalf("TotalCharge() = %d", c.TotalCharge())
+	}
+	if c.Hits() != 5 || c.Misses() != 5 {
+		t.Fatalf("hits = %d, misses = %d", c.Hits(), c.Misses())
+	}
+}
+
+func Test_LRUCache_Erase(t *testing.T) {
+	c := NewLRUCache(1000 * numShards)
+	c.Erase(encodeKey(200))
+
+	c.Insert(encodeKey(100), 101, 1)
+	c.Insert(encodeKey(200), 201, 1)
+	c.Erase(encodeKey(100))
+	if lookup(c, 100) != -1 || lookup(c, 200) != 201 {
+		t.Fatal("unexpected lookup after erase")
+	}
+	if c.TotalCharge() != 1 {
+		t.Fatalf("TotalCharge() = %d", c.TotalCharge())
+	}
+}
+
+// 同一个shard内按最近使用的顺序淘汰，容量按charge计算
+func Test_LRUCache_EvictionPolicy(t *testing.T) {
+	c := NewLRUCache(100 * numShards).(*lruCache)
+	var keys []int
+	s := c.shard(encodeKey(0))
+	for k := 0; len(keys) < 20; k++ {
+		if c.shard(encodeKey(k)) == s {
+			keys = append(keys, k)
+		}
+	}
+
+	// 每个10，共占满shard
+	for _, k := range keys[:10] {
+		c.Insert(encodeKey(k), k, 10)
+	}
+	// keys[0]最近被使用，不会被淘汰
+	lookup(c, keys[0])
+	c.Insert(encodeKey(keys[10]), keys[10], 10)
+	if lookup(c, keys[0]) != keys[0] {
+		t.Fatal("recently used entry evicted")
+	}
+	if lookup(c, keys[1]) != -1 {
+		t.Fatal("least recently used entry not evicted")
+	}
+
+	// 一个大的entry挤掉多个小的
+	c.Insert(encodeKey(keys[11]), keys[11], 55)
+	if s.usage > s.capacity {
+		t.Fatalf("usage %d > capacity %d", s.usage, s.capacity)
+	}
+	if lookup(c, keys[11]) != keys[11] {
+		t.Fatal("new entry evicted")
+	}
+
+	// 超过容量的entry也能加入，但会淘汰其他所有数据
+	c.Insert(encodeKey(keys[12]), keys[12], 1000)
+	if lookup(c, keys[12]) != keys[12] || lookup(c, keys[11]) != -1 || s.lru.Len() != 1 {
+		t.Fatal("oversized entry")
+	}
+}
+
+func Test_LRUCache_ZeroCapacity(t *testing.T) {
+	c := NewLRUCache(0)
+	c.Insert(encodeKey(1), 1, 1)
+	if lookup(c, 1) != -1 || c.TotalCharge() != 0 {
+		t.Fatal("zero capacity cache stores data")
+	}
+}
+
+func Test_LRUCache_NewId(t *testing.T) {
+	c := NewLRUCache(100)
+	a, b := c.NewId(), c.NewId()
+	if a == b {
+		t.Fatal("duplicate ids")
+	}
+}
@@ -0,0 +1,71 @@
+package compress
+
+import (
+	"fmt"
+	"sync"
+)
+
+// block的压缩算法，Type写在每个block的trailer中，读取时根据Type找到对应的Compressor
+type Compressor interface {
+	// 写入block trailer的类型，0表示不压缩，不同的Compressor不能重复
+	Type() byte
+	Name() string
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// 解压后的block不能超过这个大小，更大的block写入时不压缩
+const MaxBlockSize = 64 << 20
+
+// 内置的压缩算法，Type和c++版本保持一致：0不压缩，1是snappy
+var (
+	None   Compressor = noneCompressor{}
+	Snappy Compressor = snappyCompressor{}
+	Flate  Compressor = flateCompressor{} // c++版本中没有
+)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[byte]Compressor)
+)
+
+func init() {
+	Register(None)
+	Register(Snappy)
+	Register(Flate)
+}
+
+// 注册自定义的压缩算法，读取sstable之前需要注册写入时用到的所有算法。Type重复时panic
+func Register(c Compressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	if old, ok := registry[c.Type()]; ok {
+		panic(fmt.Sprintf("compress: type %d already registered by %s", c.Type(), old.Name()))
+	}
+	registry[c.Type()] = c
+}
+
+// 没有注册时返回nil
+func Lookup(t byte) Compressor {
+	mu.RLock()
+	defer mu.RUnlock()
+	return registry[t]
+}
+
+type noneCompressor struct{}
+
+func (noneCompressor) Type() byte {
+	return 0
+}
+
+func (noneCompressor) Name() string {
+	return "none"
+}
+
+func (noneCompressor) Compress(src []byte) []byte {
+	return src
+}
+
+func (noneCompressor) Decompress(src []byte) ([]byte, error) {
+	return src, nil
+}
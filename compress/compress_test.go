@@ -0,0 +1,131 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func testInputs() [][]byte {
+	r := rand.New(rand.NewSource(301))
+	random := make([]byte, 10000)
+	r.Read(random)
+	var json bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&json, `{"id":%d,"name":"user%d","tags":["a","b"],"score":%d}`, i, i%7, r.Intn(100))
+	}
+	return [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("hello"),
+		[]byte("abcdabcdabcdabcdabcd"),
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("0123456789"), 10000),
+		random,
+		json.Bytes(),
+	}
+}
+
+func Test_Compressor_RoundTrip(t *testing.T) {
+	for _, c := range []Compressor{None, Snappy, Flate} {
+		for _, input := range testInputs() {
+			compressed := c.Compress(input)
+			output, err := c.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%s: %v", c.Name(), err)
+			}
+			if !bytes.Equal(input, output) {
+				t.Fatalf("%s: round trip mismatch for input of length %d", c.Name(), len(input))
+			}
+		}
+	}
+}
+
+func Test_Compressor_Registry(t *testing.T) {
+	for _, c := range []Compressor{None, Snappy, Flate} {
+		if Lookup(c.Type()) != c {
+			t.Fatalf("%s not registered", c.Name())
+		}
+	}
+	if Lookup(0xff) != nil {
+		t.Fatal("unexpected compressor")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate type registered")
+		}
+	}()
+	Register(flateCompressor{})
+}
+
+// snappy格式文档中的例子
+func Test_Snappy_Format(t *testing.T) {
+	// literal "abcd"，后面是offset为4、长度为8的copy1
+	encoded := Snappy.Compress([]byte("abcdabcdabcd"))
+	expected := []byte{12, 3 << 2, 'a', 'b', 'c', 'd', (8-4)<<2 | tagCopy1, 4}
+	if !bytes.Equal(encoded, expected) {
+		t.Fatalf("encoded = %v, want %v", encoded, expected)
+	}
+	// offset为4、长度为16的copy2
+	decoded, err := Snappy.Decompress([]byte{20, 3 << 2, 'a', 'b', 'c', 'd', (16-1)<<2 | tagCopy2, 4, 0})
+	if err != nil || string(decoded) != "abcdabcdabcdabcdabcd" {
+		t.Fatalf("decoded = %q, %v", decoded, err)
+	}
+}
+
+// testdata/snappy下的文件由c++版本的snappy库压缩，见testdata/snappy/README.md
+func Test_Snappy_CppCompat(t *testing.T) {
+	for _, name := range []string{"text", "random", "pattern", "zeros", "empty"} {
+		raw, err := ioutil.ReadFile(filepath.Join("..", "testdata", "snappy", name+".raw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := ioutil.ReadFile(filepath.Join("..", "testdata", "snappy", name+".snappy"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := Snappy.Decompress(compressed)
+		if err != nil || !bytes.Equal(output, raw) {
+			t.Fatalf("%s: Decompress = %d bytes, %v, want %d bytes", name, len(output), err, len(raw))
+		}
+	}
+}
+
+func Test_Snappy_Corrupt(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		{5, 4 << 2, 'a'},                   // literal超出输入
+		{8, 3 << 2, 'a', 'b', 'c', 'd', 1}, // copy1不完整
+		{8, 0, 'a', 1<<2 | tagCopy1, 2},    // offset超出已解码的数据
+		{2, 3 << 2, 'a', 'b', 'c', 'd'},    // 比声明的长度长
+		{9, 3 << 2, 'a', 'b', 'c', 'd'},    // 比声明的长度短
+	}
+	for _, input := range inputs {
+		if _, err := Snappy.Decompress(input); err != ErrSnappyCorrupt {
+			t.Errorf("Decompress(%v) = %v", input, err)
+		}
+	}
+}
+
+func Test_Flate_Corrupt(t *testing.T) {
+	encoded := Flate.Compress([]byte("abcdabcdabcd"))
+	if _, err := Flate.Decompress(encoded[:len(encoded)-2]); err == nil {
+		t.Fatal("Decompress of truncated input succeeded")
+	}
+	// 解压后超过MaxBlockSize
+	encoded = Flate.Compress(make([]byte, MaxBlockSize+1))
+	if _, err := Flate.Decompress(encoded); err != ErrFlateCorrupt {
+		t.Fatalf("Decompress = %v, want %v", err, ErrFlateCorrupt)
+	}
+}
+
+func BenchmarkSnappy_Compress(b *testing.B) {
+	input := testInputs()[7]
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		Snappy.Compress(input)
+	}
+}
@@ -0,0 +1,44 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var ErrFlateCorrupt = errors.New("flate: corrupt input")
+
+// 标准库的deflate，压缩率比snappy高，但是更慢
+type flateCompressor struct{}
+
+func (flateCompressor) Type() byte {
+	return 3
+}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(src)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (flateCompressor) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	// 损坏的数据可能解压出很长的内容，超过MaxBlockSize就不再读
+	dst, err := ioutil.ReadAll(io.LimitReader(r, MaxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) > MaxBlockSize {
+		return nil, ErrFlateCorrupt
+	}
+	return dst, nil
+}
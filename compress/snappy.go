@@ -0,0 +1,186 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// snappy格式，和c++版本的snappy库可以互相读写
+// 格式：varint编码的原始长度，后面是若干个literal或者copy，每个以一个tag字节开头，tag的低2位是类型：
+//    00 literal：高6位是长度-1，大于等于60时长度放在后面的1~4个字节中
+//    01 copy：长度4~11，offset小于2048，占2个字节
+//    10 copy：长度1~64，offset放在后面的2个字节
+//    11 copy：长度1~64，offset放在后面的4个字节
+type snappyCompressor struct{}
+
+func (snappyCompressor) Type() byte {
+	return 1
+}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+
+	snappyHashBits = 14
+	// copy2的offset只有2个字节
+	snappyMaxOffset = 1 << 16
+)
+
+func (snappyCompressor) Compress(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)+len(src)/6+32)
+	n := binary.PutUvarint(dst, uint64(len(src)))
+	dst = dst[:n]
+
+	// 4字节的hash -> 上一次出现的位置+1，0表示没有出现过
+	var table [1 << snappyHashBits]int32
+	lit := 0 // 还没输出的literal的开始位置
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - snappyHashBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate >= snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+		// 找到一个至少4字节的匹配，尽量往后延长
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = emitLiteral(dst, src[lit:i])
+		dst = emitCopy(dst, i-candidate, length)
+		i += length
+		lit = i
+	}
+	return emitLiteral(dst, src[lit:])
+}
+
+func emitLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// REQUIRES: 0 < offset < snappyMaxOffset && length >= 4
+func emitCopy(dst []byte, offset, length int) []byte {
+	// copy2一次最多64字节，剩下的不能少于4字节
+	for length >= 68 {
+		dst = append(dst, 63<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|tagCopy1, byte(offset))
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	dLen, n := binary.Uvarint(src)
+	// 原始长度不会超过压缩后长度的很多倍，避免损坏的数据导致分配过大的内存
+	if n <= 0 || dLen > uint64(len(src))*255+64 || dLen > MaxBlockSize {
+		return nil, ErrSnappyCorrupt
+	}
+	dst := make([]byte, 0, dLen)
+	for s := n; s < len(src); {
+		tag := src[s]
+		var length, offset int
+		switch tag & 0x03 {
+		case tagLiteral:
+			x := uint32(tag >> 2)
+			switch {
+			case x < 60:
+				s++
+			case x == 60:
+				s += 2
+				if s > len(src) {
+					return nil, ErrSnappyCorrupt
+				}
+				x = uint32(src[s-1])
+			case x == 61:
+				s += 3
+				if s > len(src) {
+					return nil, ErrSnappyCorrupt
+				}
+				x = uint32(src[s-2]) | uint32(src[s-1])<<8
+			case x == 62:
+				s += 4
+				if s > len(src) {
+					return nil, ErrSnappyCorrupt
+				}
+				x = uint32(src[s-3]) | uint32(src[s-2])<<8 | uint32(src[s-1])<<16
+			default:
+				s += 5
+				if s > len(src) {
+					return nil, ErrSnappyCorrupt
+				}
+				x = binary.LittleEndian.Uint32(src[s-4:])
+			}
+			length = int(x) + 1
+			if length <= 0 || length > len(src)-s || uint64(len(dst)+length) > dLen {
+				return nil, ErrSnappyCorrupt
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case tagCopy1:
+			s += 2
+			if s > len(src) {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 4 + int(tag>>2)&0x7
+			offset = int(tag&0xe0)<<3 | int(src[s-1])
+		case tagCopy2:
+			s += 3
+			if s > len(src) {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s-2:]))
+		case tagCopy4:
+			s += 5
+			if s > len(src) {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s-4:]))
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > dLen {
+			return nil, ErrSnappyCorrupt
+		}
+		// offset小于length时是重复的数据，只能逐字节复制
+		for end := len(dst) + length; len(dst) < end; {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != dLen {
+		return nil, ErrSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -1,69 +1,202 @@
 package db
 
 import (
-	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sync/atomic"
 
 	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/version"
 )
 
+// REQUIRES: db.mu is held
 func (db *Db) maybeScheduleCompaction() {
 	if db.bgCompactionScheduled { // 最多只发起一个后台协程来写数据
 		return
 	}
+	if db.closing || db.bgErr != nil {
+		return
+	}
+	if len(db.imm) == 0 && !db.versions.NeedsCompaction() {
+		return
+	}
 	db.bgCompactionScheduled = true
 	go func() {
 		db.mu.Lock()
 		defer db.mu.Unlock()
 		db.backgroundCompaction()
 		db.bgCompactionScheduled = false
+		// 合并的时候没有持有锁，期间可能生成了新的imm，或者合并完以后下一层又需要合并
+		db.maybeScheduleCompaction()
 		db.cond.Broadcast()
 	}()
 }
 
 // https://wingsxdu.com/post/database/leveldb/#tablecache
+// 每次只做一件事：最早的imm刷盘，或者合并一次，耗时的文件读写都不持有锁
+// 合并过程中生成的imm不需要等合并结束，由DoCompactionWork回调maybeCompactMemTable刷盘
+// REQUIRES: db.mu is held
 func (db *Db) backgroundCompaction() {
-	// 先复制一份，然后就可以释放锁，用户可以继续写。但是提交会卡主，需要等到imm完全写到文件后释放。
-	imm := db.imm                // 可以不用深拷贝，因为imm未刷盘时不会有新的imm生成 TODO 是这个意思?
-	version := db.current.Copy() // 需要深拷贝，虽然不会有新的sstable生成，但是version字段会更新，如果有查询操作会出现问题 TODO 是这个意思?
-	//db.mu.Unlock()
-
 	// minor compaction：写imm到sstable，L0文件之间是没有关系的。
 	// 如果发现sstable可以属于L1的sstable子集，优先向下合并。
-	if imm != nil {
-		version.WriteLevel0Table(imm)
+	if len(db.imm) > 0 {
+		db.compactMemTable(internal.MaxMemCompactLevel)
+		return
 	}
+
 	// major compaction：合并，L1之后的sstable文件之前是单调增的
-	for version.DoCompactionWork() {
-		// 每次合并后打印下version信息，除了看，没啥用
-		version.Log()
-	}
-	// 写新的MANIFEST文件信息，因为version信息已经变更，需要及时更新元信息
-	descriptorNumber, _ := version.Save()
-	// 更新CURRENT文件内容
-	db.SetCurrentFile(descriptorNumber)
-	//db.mu.Lock()
-	db.imm = nil
-	db.current = version
+	c := db.versions.PickCompaction()
+	if c == nil {
+		return
+	}
+	// 最老的快照能看到的版本都要保留
+	smallestSnapshot := db.versions.LastSequence()
+	if !db.snapshots.empty() {
+		smallestSnapshot = db.snapshots.oldest().sequence
+	}
+	db.mu.Unlock()
+	err := c.DoCompactionWork(db.newOutputFileNumber, smallestSnapshot, db.maybeCompactMemTable)
+	db.mu.Lock()
+	if err == nil {
+		err = db.versions.LogAndApply(c.Edit())
+	}
+	c.ReleaseInputs()
+	db.pendingOutputs = make(map[uint64]bool)
+	if err != nil {
+		db.recordBackgroundError(err)
+		return
+	}
+	// 每次合并后打印下version信息，除了看，没啥用
+	db.versions.Current().Log()
+	db.deleteObsoleteFiles()
 }
 
-//更新current文件里面的值，为了保证原子操作，此处用mv来实现
-func (db *Db) SetCurrentFile(descriptorNumber uint64) {
-	tmp := internal.TempFileName(db.name, descriptorNumber)
-	ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d", descriptorNumber)), 0600)
-	os.Rename(tmp, internal.CurrentFileName(db.name))
+// 合并过程中有imm等待刷盘时先刷盘，避免写入一直等到合并结束
+// 合并的输出文件还没有生效，可能和imm的key范围重叠，所以imm只放到L0
+func (db *Db) maybeCompactMemTable() {
+	if atomic.LoadInt32(&db.hasImm) == 0 {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.imm) > 0 && db.bgErr == nil {
+		db.compactMemTable(0)
+	}
 }
 
-func (db *Db) ReadCurrentFile() uint64 {
-	b, err := ioutil.ReadFile(internal.CurrentFileName(db.name))
+// 最早的imm写到不超过maxLevel的level
+// REQUIRES: db.mu is held
+func (db *Db) compactMemTable(maxLevel int) {
+	// imm已经不会再修改，刷盘时不需要拷贝
+	imm := db.imm[0].mem
+	base := db.versions.Current()
+	base.Ref()
+	number := db.versions.NewFileNumber()
+	db.pendingOutputs[number] = true
+
+	// 写sstable的时候不持有锁，用户可以继续读写
+	var edit version.VersionEdit
+	db.mu.Unlock()
+	err := base.WriteLevel0Table(imm, number, &edit, maxLevel)
+	db.mu.Lock()
+	base.Unref()
 	if err != nil {
-		return 0
+		// imm和它的wal都保留，出错以后不会再删除文件
+		delete(db.pendingOutputs, number)
+		db.recordBackgroundError(err)
+		return
 	}
-	descriptorNumber, err := strconv.ParseUint(string(b), 10, 64)
+
+	// imm对应的wal已经没用了，之后只需要回放下一个imm或者mem对应的wal
+	if len(db.imm) > 1 {
+		edit.SetLogNumber(db.imm[1].logfileNumber)
+	} else {
+		edit.SetLogNumber(db.logfileNumber)
+	}
+	// 写MANIFEST，因为version信息已经变更，需要及时更新元信息
+	err = db.versions.LogAndApply(&edit)
+	delete(db.pendingOutputs, number)
 	if err != nil {
-		return 0
+		db.recordBackgroundError(err)
+		return
+	}
+	db.imm[0] = immMemTable{}
+	db.imm = db.imm[1:]
+	if len(db.imm) == 0 {
+		atomic.StoreInt32(&db.hasImm, 0)
+	}
+	// 等待imm刷盘的写请求可以继续了
+	db.cond.Broadcast()
+	db.deleteObsoleteFiles()
+}
+
+// 合并过程中分配新的文件号，文件生效前不能被当做无用文件删除
+func (db *Db) newOutputFileNumber() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	number := db.versions.NewFileNumber()
+	db.pendingOutputs[number] = true
+	return number
+}
+
+// 后台任务失败后，之后的写入都直接返回这个错误
+// REQUIRES: db.mu is held
+func (db *Db) recordBackgroundError(err error) {
+	if db.bgErr == nil {
+		log.Printf("background error: %v", err)
+		db.bgErr = err
+		db.cond.Broadcast()
+	}
+}
+
+// 扫描db目录，删除已经不会再用到的文件：
+//    不在version中的sstable
+//    已经持久化到sstable的wal
+//    旧的MANIFEST
+//    写CURRENT时残留的临时文件
+// REQUIRES: db.mu is held
+func (db *Db) deleteObsoleteFiles() {
+	if db.bgErr != nil {
+		// 不确定最后一次修改有没有写到MANIFEST，不能删除
+		return
+	}
+	// 正在写的sstable还没有记录到version中
+	live := make(map[uint64]bool)
+	for number := range db.pendingOutputs {
+		live[number] = true
+	}
+	db.versions.AddLiveFiles(live)
+
+	files, err := ioutil.ReadDir(db.name)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		number, fileType, ok := internal.ParseFileName(f.Name())
+		if !ok {
+			continue
+		}
+		keep := true
+		switch fileType {
+		case internal.LogFile:
+			keep = number >= db.versions.LogNumber()
+		case internal.DescriptorFile:
+			keep = number >= db.versions.ManifestFileNumber()
+		case internal.TableFile:
+			keep = live[number]
+		case internal.TempFile:
+			// CURRENT都是在持有锁的时候写完的，这时候的临时文件都是残留的
+			keep = false
+		}
+		if keep {
+			continue
+		}
+		if fileType == internal.TableFile {
+			db.versions.TableCache().Evict(number)
+		}
+		log.Printf("delete obsolete file: %s", f.Name())
+		os.Remove(filepath.Join(db.name, f.Name()))
 	}
-	return descriptorNumber
 }
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/internal"
+	wal "github.com/merlin82/leveldb/log"
+)
+
+// testdata/leveldb/db是c++版本的LevelDB写的db目录，还没有生成时跳过，见testdata/leveldb/README.md
+func compatDbDir(t *testing.T) string {
+	dir := filepath.Join("..", "testdata", "leveldb", "db")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		t.Skipf("%s has not been generated, see testdata/leveldb/README.md", dir)
+	}
+	return dir
+}
+
+// 和testdata/leveldb/gen_fixtures.cc中WriteDb第一次打开时的写入一致
+func compatSession1Batches() []*WriteBatch {
+	var batches []*WriteBatch
+	for i := 0; i < 200; i++ {
+		batch := NewWriteBatch()
+		batch.Put([]byte(fmt.Sprintf("key%03d", i)), bytes.Repeat([]byte(fmt.Sprintf("value%03d-", i)), 1+i%4))
+		batch.setSequence(uint64(i + 1))
+		batches = append(batches, batch)
+	}
+	batch := NewWriteBatch()
+	batch.Put([]byte("key000"), []byte("updated"))
+	batch.Delete([]byte("key001"))
+	batch.Put([]byte("key200"), []byte("new"))
+	batch.setSequence(201)
+	return append(batches, batch)
+}
+
+// 第二次打开时的写入，还留在wal中
+func compatSession2Batches() []*WriteBatch {
+	first := NewWriteBatch()
+	first.Delete([]byte("key002"))
+	first.Put([]byte("key003"), []byte("session2"))
+	first.setSequence(204)
+	big := make([]byte, 50000)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	second := NewWriteBatch()
+	second.Put([]byte("big"), big)
+	second.setSequence(206)
+	return []*WriteBatch{first, second}
+}
+
+// c++版本的wal和Go写出的相同
+func Test_Compat_WAL(t *testing.T) {
+	logs, err := filepath.Glob(filepath.Join(compatDbDir(t), "*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("wal files = %v, %v", logs, err)
+	}
+	want, err := ioutil.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := wal.NewWriter(&buf)
+	for _, batch := range compatSession2Batches() {
+		if err := w.AddRecord(batch.Contents()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("%s: %d bytes, want %d bytes", logs[0], buf.Len(), len(want))
+	}
+}
+
+func Test_Compat_OpenLevelDB(t *testing.T) {
+	dir := compatDbDir(t)
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		p, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dbName, f.Name()), p, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := make(map[string][]byte)
+	for _, batches := range [][]*WriteBatch{compatSession1Batches(), compatSession2Batches()} {
+		for _, batch := range batches {
+			batch.iterate(func(valueType internal.ValueType, key, value []byte) {
+				if valueType == internal.TypeValue {
+					expected[string(key)] = value
+				} else {
+					delete(expected, string(key))
+				}
+			})
+		}
+	}
+	var db *Db
+	check := func() {
+		for key, value := range expected {
+			got, err := db.Get([]byte(key), &ReadOptions{VerifyChecksums: true})
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("Get(%s) = %.20s, %v", key, got, err)
+			}
+		}
+		for _, key := range []string{"key001", "key002"} {
+			if _, err := db.Get([]byte(key), nil); err == nil {
+				t.Fatalf("Get(%s) found deleted key", key)
+			}
+		}
+	}
+
+	db, err = Open(dbName, &Options{LevelDBCompatible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+	db.Close()
+
+	// 重新打开以后wal中的写入已经写到了Go生成的sstable
+	db, err = Open(dbName, &Options{LevelDBCompatible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+	db.Close()
+}
+
+func Test_Db_LevelDBCompatible(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+
+	if _, err := Open(dbName, &Options{LevelDBCompatible: true, Compressor: compress.Flate}); err != internal.ErrUnsupportedCompressor {
+		t.Fatalf("Open = %v, want %v", err, internal.ErrUnsupportedCompressor)
+	}
+
+	db, err := Open(dbName, &Options{LevelDBCompatible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		if err := db.Put(key, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	current, err := ioutil.ReadFile(internal.CurrentFileName(dbName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^MANIFEST-[0-9]{6}\n$`).Match(current) {
+		t.Fatalf("CURRENT = %q", current)
+	}
+
+	// c++版本的footer：两个varint64 BlockHandle补齐到40字节，后面是magic，index block紧挨着footer
+	files, err := ioutil.ReadDir(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := 0
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".ldb" {
+			continue
+		}
+		tables++
+		p, err := ioutil.ReadFile(filepath.Join(dbName, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		footer := p[len(p)-48:]
+		if magic := binary.LittleEndian.Uint64(footer[40:]); magic != 0xdb4775248b80fb57 {
+			t.Fatalf("%s: magic = %x", f.Name(), magic)
+		}
+		// 跳过metaindex的offset和size
+		_, n := binary.Uvarint(footer)
+		_, m := binary.Uvarint(footer[n:])
+		footer = footer[n+m:]
+		indexOffset, n := binary.Uvarint(footer)
+		indexSize, _ := binary.Uvarint(footer[n:])
+		if indexOffset+indexSize+5 != uint64(len(p)-48) {
+			t.Fatalf("%s: index block at %d, size %d, file size %d", f.Name(), indexOffset, indexSize, len(p))
+		}
+	}
+	if tables == 0 {
+		t.Fatal("no sstable written")
+	}
+}
@@ -2,104 +2,291 @@ package db
 
 import (
 	"log"
+	"os"
 	"sync"
+	"sync/atomic"
 
 	"time"
 
+	"github.com/merlin82/leveldb/compress"
 	"github.com/merlin82/leveldb/internal"
+	wal "github.com/merlin82/leveldb/log"
 	"github.com/merlin82/leveldb/memtable"
+	"github.com/merlin82/leveldb/sstable"
 	"github.com/merlin82/leveldb/version"
 )
 
 type Db struct {
 	name                  string
+	opts                  Options
 	mu                    sync.Mutex
 	cond                  *sync.Cond
 	mem                   *memtable.MemTable
-	imm                   *memtable.MemTable
-	current               *version.Version
+	imm                   []immMemTable // 等待刷盘的mem，最早生成的在前面
+	hasImm                int32         // imm不为空时是1，合并过程中不加锁检查
+	versions              *version.VersionSet
+	logfileNumber         uint64 // mem对应的wal文件号
+	logfile               *os.File
+	log                   *wal.Writer
+	writers               []*writer       // 等待写入的队列，队首的writer负责合并后面的batch一起提交
+	tmpBatch              WriteBatch      // 合并多个batch时复用
+	pendingOutputs        map[uint64]bool // 后台任务正在写的sstable
+	snapshots             snapshotList
 	bgCompactionScheduled bool
+	bgErr                 error
+	closing               bool
 }
 
-func Open(dbName string) *Db {
+// 已经写满的mem，以及它对应的wal文件号
+type immMemTable struct {
+	mem           *memtable.MemTable
+	logfileNumber uint64
+}
+
+// 排队中的一次写请求
+type writer struct {
+	batch      *WriteBatch
+	sync       bool
+	disableWAL bool
+	err        error
+	done       bool
+	cond       *sync.Cond
+}
+
+func Open(dbName string, opts *Options) (*Db, error) {
 	var db Db
 	db.name = dbName
+	db.opts = opts.sanitize()
+	if db.opts.LevelDBCompatible && db.opts.Compressor.Type() != compress.None.Type() && db.opts.Compressor.Type() != compress.Snappy.Type() {
+		return nil, internal.ErrUnsupportedCompressor
+	}
 	db.mem = memtable.New()
 	db.imm = nil
 	db.bgCompactionScheduled = false
 	db.cond = sync.NewCond(&db.mu)
-	// 最新一次的MANIFEST文件号
-	num := db.ReadCurrentFile()
-	if num > 0 {
-		v, err := version.Load(dbName, num)
-		if err != nil {
-			return nil
-		}
-		db.current = v
-	} else {
-		db.current = version.New(dbName)
+	db.pendingOutputs = make(map[uint64]bool)
+	db.snapshots.init()
+	if err := os.MkdirAll(dbName, 0755); err != nil {
+		return nil, err
 	}
+	// 回放MANIFEST恢复version
+	tableOpts := &sstable.Options{
+		FilterPolicy:         db.opts.FilterPolicy,
+		BlockCache:           db.opts.BlockCache,
+		Compressor:           db.opts.Compressor,
+		BlockRestartInterval: db.opts.BlockRestartInterval,
+	}
+	if db.opts.LevelDBCompatible {
+		tableOpts.FormatVersion = sstable.FormatLevelDB
+	}
+	db.versions = version.NewVersionSet(dbName, tableOpts)
+	if err := db.versions.Recover(); err != nil {
+		return nil, err
+	}
+	// 回放上次没有持久化到sstable的wal
+	if err := db.recover(); err != nil {
+		db.versions.Close()
+		return nil, err
+	}
+	db.deleteObsoleteFiles()
+	db.maybeScheduleCompaction()
 
-	return &db
+	return &db, nil
 }
 
 func (db *Db) Close() {
 	db.mu.Lock()
+	// 正在执行的后台任务做完后不再发起新的
+	db.closing = true
 	for db.bgCompactionScheduled {
 		db.cond.Wait()
 	}
+	if db.logfile != nil {
+		db.logfile.Close()
+		db.logfile = nil
+		db.log = nil
+	}
+	db.versions.Close()
 	db.mu.Unlock()
 }
 
-func (db *Db) Put(key, value []byte) error {
-	// May temporarily unlock and wait.
-	seq, err := db.makeRoomForWrite()
-	if err != nil {
-		return err
-	}
-
-	// todo : add log
-
-	db.mem.Add(seq, internal.TypeValue, key, value)
-	return nil
+func (db *Db) Put(key, value []byte, opts *WriteOptions) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return db.Write(batch, opts)
 }
 
-func (db *Db) Get(key []byte) ([]byte, error) {
+func (db *Db) Get(key []byte, opts *ReadOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &defaultReadOptions
+	}
 	db.mu.Lock()
+	var lookupKey *internal.InternalKey
+	if opts.Snapshot != nil {
+		lookupKey = internal.LookupKey(key, opts.Snapshot.sequence)
+	} else {
+		lookupKey = internal.LookupKey(key, db.versions.LastSequence())
+	}
 	mem := db.mem
-	imm := db.mem
-	current := db.current
+	imm := append([]immMemTable(nil), db.imm...)
+	current := db.versions.Current()
+	// 读的过程中version可能被替换，引用住避免里面的文件被删除
+	current.Ref()
 	db.mu.Unlock()
-	value, err := mem.Get(key)
+	defer func() {
+		db.mu.Lock()
+		current.Unref()
+		db.mu.Unlock()
+	}()
+
+	value, err := mem.Get(lookupKey)
 	if err != internal.ErrNotFound {
 		return value, err
 	}
 
-	if imm != nil {
-		value, err := imm.Get(key)
+	// 越晚生成的imm里面的数据越新
+	for i := len(imm) - 1; i >= 0; i-- {
+		value, err := imm[i].mem.Get(lookupKey)
 		if err != internal.ErrNotFound {
 			return value, err
 		}
 	}
 
-	value, err = current.Get(key)
+	// Get读到的block总是放入block cache
+	value, err = current.Get(lookupKey, &sstable.ReadOptions{VerifyChecksums: opts.VerifyChecksums, FillCache: true})
 	return value, err
 }
 
-func (db *Db) Delete(key []byte) error {
-	seq, err := db.makeRoomForWrite()
-	if err != nil {
-		return err
+func (db *Db) Delete(key []byte, opts *WriteOptions) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.Write(batch, opts)
+}
+
+// batch里的记录分配连续的lsn，作为一条记录写wal，然后一起写到mem
+// 并发写入时排队，队首的writer作为leader把后面排队的batch合并成一个，
+// 只写一次wal，写完后唤醒被合并的writer直接返回
+func (db *Db) Write(batch *WriteBatch, opts *WriteOptions) error {
+	if batch.Len() == 0 {
+		return nil
 	}
-	db.mem.Add(seq, internal.TypeDeletion, key, nil)
-	return nil
+	if opts == nil {
+		opts = &defaultWriteOptions
+	}
+	w := writer{batch: batch, sync: opts.Sync, disableWAL: opts.DisableWAL, cond: sync.NewCond(&db.mu)}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.writers = append(db.writers, &w)
+	for !w.done && db.writers[0] != &w {
+		w.cond.Wait()
+	}
+	if w.done {
+		// 已经被leader合并提交了
+		return w.err
+	}
+
+	// May temporarily unlock and wait.
+	err := db.makeRoomForWrite()
+	lastWriter := &w
+	if err == nil {
+		var group *WriteBatch
+		group, lastWriter = db.buildBatchGroup()
+		seq := db.versions.LastSequence() + 1
+		group.setSequence(seq)
+
+		// 只有队首的writer会走到这里，写wal和mem的时候可以释放锁，
+		// 让后面的writer继续排队
+		db.mu.Unlock()
+		// 先写wal再写mem，崩溃后可以通过wal恢复mem中的数据
+		walFailed := false
+		if !w.disableWAL {
+			err = db.log.AddRecord(group.Contents())
+			if err == nil && w.sync {
+				err = db.logfile.Sync()
+			}
+			walFailed = err != nil
+		}
+		if err == nil {
+			err = group.insertInto(db.mem)
+		}
+		db.mu.Lock()
+		if walFailed {
+			// wal里可能已经有这条记录的一部分或者全部，之后的写入不能再用这些sequence，
+			// 也不能接着写这个wal，所以之后的写入都返回这个错误
+			db.recordBackgroundError(err)
+		}
+		if err == nil {
+			db.versions.SetLastSequence(seq + uint64(group.Len()) - 1)
+		}
+		if group == &db.tmpBatch {
+			db.tmpBatch.Clear()
+		}
+	}
+
+	// 通知被合并的writer写入结果
+	for {
+		ready := db.writers[0]
+		db.writers[0] = nil
+		db.writers = db.writers[1:]
+		if ready != &w {
+			ready.err = err
+			ready.done = true
+			ready.cond.Signal()
+		}
+		if ready == lastWriter {
+			break
+		}
+	}
+	// 唤醒下一个leader
+	if len(db.writers) > 0 {
+		db.writers[0].cond.Signal()
+	}
+	return err
+}
+
+// 从队首开始合并排队的batch，返回合并后的batch和最后一个被合并的writer
+// 合并后的大小有上限，避免小的写请求被大batch拖慢
+// 需要sync的writer不能合并到不sync的leader里，写不写wal也必须一致
+// REQUIRES: db.mu is held, db.writers is not empty
+func (db *Db) buildBatchGroup() (*WriteBatch, *writer) {
+	first := db.writers[0]
+	result := first.batch
+	lastWriter := first
+
+	size := len(first.batch.Contents())
+	maxSize := 1 << 20
+	if size <= 128<<10 {
+		maxSize = size + 128<<10
+	}
+	for _, w := range db.writers[1:] {
+		if w.sync && !first.sync {
+			break
+		}
+		if w.disableWAL != first.disableWAL {
+			break
+		}
+		size += len(w.batch.Contents()) - writeBatchHeaderSize
+		if size > maxSize {
+			break
+		}
+		if result == first.batch {
+			// 不能修改调用方的batch，合并到tmpBatch里
+			result = &db.tmpBatch
+			result.Clear()
+			result.Append(first.batch)
+		}
+		result.Append(w.batch)
+		lastWriter = w
+	}
+	return result, lastWriter
 }
 
 // 写入速度下降的case：
 //    当0层sstable文件多余8个时候，用户写会被降低；
 // 写入被限制的case：
 //    当0层sstable文件大于12个停止写入；
-//    mem超过阈值转为imm，imm未持久化到sstable停止写入
+//    mem超过阈值转为imm，imm个数达到上限并且都未持久化到sstable时停止写入
 // 触发合并的两个case:
 //	  0层超过4个文件开始合并
 //	  其他层数据库超过层级最大值开始合并
@@ -107,31 +294,36 @@ func (db *Db) Delete(key []byte) error {
 //    加锁，导致写只能串行；
 //    cond引入导致可以写，但是提交时间会变长（返回时间变长）
 //    其他场景通过内存拷本副本方式，降低block时间
-func (db *Db) makeRoomForWrite() (uint64, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
+// REQUIRES: db.mu is held
+func (db *Db) makeRoomForWrite() error {
 	for true {
-		if db.current.NumLevelFiles(0) >= internal.L0_SlowdownWritesTrigger {
+		if db.bgErr != nil {
+			return db.bgErr
+		} else if db.versions.NumLevelFiles(0) >= internal.L0_SlowdownWritesTrigger {
 			// L0超过8个文件就写的慢一点，后台merge跟不上，并且L0文件之间是无序的
 			db.mu.Unlock()
 			time.Sleep(time.Duration(1000) * time.Microsecond)
 			db.mu.Lock()
 		} else if db.mem.ApproximateMemoryUsage() <= internal.Write_buffer_size {
 			// mem还没达到阈值，可以继续写
-			return db.current.NextSeq(), nil
-		} else if db.imm != nil {
-			// imm还没持久化到文件，不可写。此处可以优化成可以继续写，当mem满了且imm没持久化完成时在限制写入
+			return nil
+		} else if len(db.imm) >= db.opts.MaxImmutableMemTables {
+			// imm太多，刷盘跟不上，等最早的imm持久化到文件
 			db.cond.Wait()
 		} else {
-			// mem达到阈值，且没有imm时候，需要持久化到sstable
-			db.imm = db.mem
+			// mem达到阈值，转为imm排队持久化到sstable，不需要等待；新的mem写到新的wal文件
+			imm := immMemTable{mem: db.mem, logfileNumber: db.logfileNumber}
+			if err := db.newLogFile(); err != nil {
+				return err
+			}
+			db.imm = append(db.imm, imm)
+			atomic.StoreInt32(&db.hasImm, 1)
 			db.mem = memtable.New()
 			db.maybeScheduleCompaction()
 		}
 	}
 
-	return db.current.NextSeq(), nil
+	return nil
 }
 
 func (db *Db) PrintMem() {
@@ -141,6 +333,6 @@ func (db *Db) PrintMem() {
 }
 
 func (db *Db) PrintVersion() {
-	log.Printf("\n" + db.current.Print())
+	log.Printf("\n" + db.versions.Current().Print())
 	log.Println()
 }
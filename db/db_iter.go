@@ -0,0 +1,297 @@
+package db
+
+import (
+	"math"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/sstable"
+	"github.com/merlin82/leveldb/version"
+)
+
+type Iterator interface {
+	// Returns true iff the iterator is positioned at a valid node.
+	Valid() bool
+
+	// Returns the key at the current position.
+	// REQUIRES: Valid()
+	Key() []byte
+
+	// Return the value for the current entry.  The underlying storage for
+	// the returned slice is valid only until the next modification of
+	// the iterator.
+	// REQUIRES: Valid()
+	Value() []byte
+
+	// Advances to the next position.
+	// REQUIRES: Valid()
+	Next()
+
+	// Advances to the previous position.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Advance to the first entry with a key >= target
+	Seek(target []byte)
+
+	// Position at the first entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToFirst()
+
+	// Position at the last entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToLast()
+
+	// 遍历过程中遇到的错误，比如sstable中的block损坏，出错的数据会被跳过。
+	// Valid()为false时需要检查Error()区分是遍历完了还是出错了
+	Error() error
+
+	// 释放迭代器引用的version，之后不能再使用
+	Close()
+}
+
+// 合并memtable、imm和所有sstable的迭代器
+//    同一个user key只返回sequence最大的记录，被删除的key跳过
+//    sequence大于快照或者创建迭代器时lastSequence的记录不可见
+//    只返回[lowerBound, upperBound)范围内的key
+// 正向遍历时iter指向当前key的记录；
+// 反向遍历时iter指向当前key所有记录的前面，当前的key和value保存在savedKey和savedValue中
+type dbIter struct {
+	db         *Db
+	version    *version.Version
+	iter       *version.MergingIterator
+	sequence   uint64
+	direction  int
+	valid      bool
+	savedKey   []byte
+	savedValue []byte
+	lowerBound []byte
+	upperBound []byte
+}
+
+const (
+	forward = iota
+	reverse
+)
+
+// 遍历整个db，用完以后需要调用Close
+func (db *Db) NewIterator(opts *ReadOptions) Iterator {
+	if opts == nil {
+		opts = &defaultReadOptions
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var list []internal.Iterator
+	list = append(list, db.mem.NewIterator())
+	for i := len(db.imm) - 1; i >= 0; i-- {
+		list = append(list, db.imm[i].mem.NewIterator())
+	}
+	lowerBound, upperBound := opts.LowerBound, opts.UpperBound
+	if opts.Prefix != nil {
+		if lowerBound == nil || internal.UserKeyComparator(lowerBound, opts.Prefix) < 0 {
+			lowerBound = opts.Prefix
+		}
+		if limit := prefixSuccessor(opts.Prefix); limit != nil && (upperBound == nil || internal.UserKeyComparator(limit, upperBound) < 0) {
+			upperBound = limit
+		}
+	}
+	current := db.versions.Current()
+	current.Ref()
+	list = current.AddIterators(list, lowerBound, upperBound, &sstable.ReadOptions{VerifyChecksums: opts.VerifyChecksums, FillCache: opts.FillCache})
+
+	var it dbIter
+	it.db = db
+	it.lowerBound = lowerBound
+	it.upperBound = upperBound
+	it.version = current
+	it.iter = version.NewMergingIterator(list)
+	if opts.Snapshot != nil {
+		it.sequence = opts.Snapshot.sequence
+	} else {
+		it.sequence = db.versions.LastSequence()
+	}
+	return &it
+}
+
+func (it *dbIter) Valid() bool {
+	return it.valid
+}
+
+func (it *dbIter) Key() []byte {
+	if it.direction == forward {
+		return it.iter.InternalKey().UserKey
+	}
+	return it.savedKey
+}
+
+func (it *dbIter) Value() []byte {
+	if it.direction == forward {
+		return it.iter.InternalKey().UserValue
+	}
+	return it.savedValue
+}
+
+func (it *dbIter) Next() {
+	if it.direction == reverse {
+		it.direction = forward
+		// iter指向当前key的前面，先移动到当前key的记录上，savedKey就是要跳过的key
+		if !it.iter.Valid() {
+			it.iter.SeekToFirst()
+		} else {
+			it.iter.Next()
+		}
+	} else {
+		// 跳过当前key更早的版本
+		it.savedKey = append(it.savedKey[:0], it.Key()...)
+		it.iter.Next()
+	}
+	it.findNextUserEntry(true, it.savedKey)
+}
+
+func (it *dbIter) Prev() {
+	if it.direction == forward {
+		// iter指向当前的记录，向前找到第一个不同的user key
+		it.savedKey = append(it.savedKey[:0], it.Key()...)
+		for {
+			it.iter.Prev()
+			if !it.iter.Valid() {
+				it.valid = false
+				it.savedKey = it.savedKey[:0]
+				it.savedValue = nil
+				return
+			}
+			if internal.UserKeyComparator(it.iter.InternalKey().UserKey, it.savedKey) < 0 {
+				break
+			}
+		}
+		it.direction = reverse
+	}
+	it.findPrevUserEntry()
+}
+
+func (it *dbIter) Seek(target []byte) {
+	if it.lowerBound != nil && internal.UserKeyComparator(target, it.lowerBound) < 0 {
+		target = it.lowerBound
+	}
+	it.direction = forward
+	it.savedValue = nil
+	it.iter.Seek(internal.NewInternalKey(it.sequence, internal.TypeValue, target, nil))
+	it.findNextUserEntry(false, nil)
+}
+
+func (it *dbIter) SeekToFirst() {
+	if it.lowerBound != nil {
+		it.Seek(it.lowerBound)
+		return
+	}
+	it.direction = forward
+	it.savedValue = nil
+	it.iter.SeekToFirst()
+	it.findNextUserEntry(false, nil)
+}
+
+func (it *dbIter) SeekToLast() {
+	it.direction = reverse
+	it.savedValue = nil
+	if it.upperBound != nil {
+		// 定位到最后一条<upperBound的记录
+		it.iter.Seek(internal.LookupKey(it.upperBound, math.MaxUint64))
+		if it.iter.Valid() {
+			it.iter.Prev()
+		} else {
+			it.iter.SeekToLast()
+		}
+	} else {
+		it.iter.SeekToLast()
+	}
+	it.findPrevUserEntry()
+}
+
+func (it *dbIter) Error() error {
+	return it.iter.Error()
+}
+
+func (it *dbIter) Close() {
+	it.iter.Close()
+	it.db.mu.Lock()
+	defer it.db.mu.Unlock()
+	if it.version != nil {
+		it.version.Unref()
+		it.version = nil
+	}
+}
+
+// 从当前位置开始找第一个可见的key
+//    skipping为true时，所有<=skip的user key都已经返回过或者被删除了
+func (it *dbIter) findNextUserEntry(skipping bool, skip []byte) {
+	for ; it.iter.Valid(); it.iter.Next() {
+		ikey := it.iter.InternalKey()
+		if it.upperBound != nil && internal.UserKeyComparator(ikey.UserKey, it.upperBound) >= 0 {
+			break
+		}
+		if ikey.Seq > it.sequence {
+			continue
+		}
+		switch ikey.Type {
+		case internal.TypeDeletion:
+			// 后面同一个key更早的版本都被删除了
+			skip = append(skip[:0], ikey.UserKey...)
+			skipping = true
+		case internal.TypeValue:
+			if skipping && internal.UserKeyComparator(ikey.UserKey, skip) <= 0 {
+				continue
+			}
+			it.valid = true
+			return
+		}
+	}
+	it.valid = false
+}
+
+// 从当前位置向前找可见的key，同一个user key的记录从旧到新出现，
+// 一直向前走到更小的user key，最后看到的记录就是这个key最新的版本
+func (it *dbIter) findPrevUserEntry() {
+	valueType := internal.TypeDeletion
+	for ; it.iter.Valid(); it.iter.Prev() {
+		ikey := it.iter.InternalKey()
+		if it.lowerBound != nil && internal.UserKeyComparator(ikey.UserKey, it.lowerBound) < 0 {
+			break
+		}
+		if ikey.Seq > it.sequence {
+			continue
+		}
+		if valueType != internal.TypeDeletion && internal.UserKeyComparator(ikey.UserKey, it.savedKey) < 0 {
+			// 已经找到了一个没有被删除的key，并且走到了前一个user key
+			break
+		}
+		valueType = ikey.Type
+		if valueType == internal.TypeDeletion {
+			it.savedKey = it.savedKey[:0]
+			it.savedValue = nil
+		} else {
+			it.savedKey = append(it.savedKey[:0], ikey.UserKey...)
+			it.savedValue = append(it.savedValue[:0], ikey.UserValue...)
+		}
+	}
+	if valueType == internal.TypeDeletion {
+		// 前面没有可见的key了
+		it.valid = false
+		it.savedKey = it.savedKey[:0]
+		it.savedValue = nil
+		it.direction = forward
+	} else {
+		it.valid = true
+	}
+}
+
+// 比所有以prefix开头的key都大的最小key，prefix全是0xff时返回nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			limit := append([]byte(nil), prefix[:i+1]...)
+			limit[i]++
+			return limit
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/merlin82/leveldb/cache"
+)
+
+func Test_Db_Iterator(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 数据分布在mem、imm和多个level中，并且有覆盖和删除
+	expected := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("%05d", r.Intn(1000))
+		if r.Intn(5) == 0 {
+			db.Delete([]byte(key), nil)
+			delete(expected, key)
+		} else {
+			value := fmt.Sprintf("value-%d", i)
+			db.Put([]byte(key), []byte(value), nil)
+			expected[key] = value
+		}
+	}
+	var keys []string
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	it := db.NewIterator(nil)
+	defer it.Close()
+	i := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if i >= len(keys) || string(it.Key()) != keys[i] || string(it.Value()) != expected[keys[i]] {
+			t.Fatalf("entry %d: got %s=%s, want %s=%s", i, it.Key(), it.Value(), keys[i], expected[keys[i]])
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("got %d entries, want %d", i, len(keys))
+	}
+	i = len(keys) - 1
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if i < 0 || string(it.Key()) != keys[i] || string(it.Value()) != expected[keys[i]] {
+			t.Fatalf("reverse entry %d: got %s=%s", i, it.Key(), it.Value())
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("reverse got %d entries, want %d", len(keys)-1-i, len(keys))
+	}
+
+	// 迭代器创建以后的写入不可见
+	db.Put([]byte("00000-new"), []byte("new"), nil)
+	it.Seek([]byte("00000-new"))
+	if it.Valid() && string(it.Key()) == "00000-new" {
+		t.Fatalf("write after NewIterator is visible")
+	}
+
+	target := keys[len(keys)/2]
+	it.Seek([]byte(target))
+	if !it.Valid() || string(it.Key()) != target {
+		t.Fatalf("Seek(%s) failed", target)
+	}
+	it.Prev()
+	if !it.Valid() || string(it.Key()) != keys[len(keys)/2-1] {
+		t.Fatalf("Prev failed")
+	}
+	it.Next()
+	if !it.Valid() || string(it.Key()) != target {
+		t.Fatalf("Next after Prev failed")
+	}
+	// 随机切换方向
+	i = len(keys) / 2
+	for step := 0; step < 1000; step++ {
+		if r.Intn(2) == 0 && i+1 < len(keys) {
+			it.Next()
+			i++
+		} else if i > 0 {
+			it.Prev()
+			i--
+		}
+		if !it.Valid() || string(it.Key()) != keys[i] || string(it.Value()) != expected[keys[i]] {
+			t.Fatalf("step %d: got %s, want %s", step, it.Key(), keys[i])
+		}
+	}
+}
+
+func Test_Db_IteratorBounds(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var all []string
+	for _, tenant := range []string{"1", "12", "123", "2"} {
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("tenant/%s/%04d", tenant, i)
+			db.Put([]byte(key), []byte(key), nil)
+			all = append(all, key)
+		}
+	}
+	sort.Strings(all)
+
+	// 返回opts范围内的key，正向和反向遍历的结果必须一致
+	scan := func(opts *ReadOptions) []string {
+		it := db.NewIterator(opts)
+		defer it.Close()
+		var keys []string
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if string(it.Key()) != string(it.Value()) {
+				t.Fatalf("%s=%s", it.Key(), it.Value())
+			}
+			keys = append(keys, string(it.Key()))
+		}
+		n := len(keys)
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+			n--
+			if n < 0 || string(it.Key()) != keys[n] {
+				t.Fatalf("reverse: got %s", it.Key())
+			}
+		}
+		if n != 0 {
+			t.Fatalf("reverse: missing %d keys", n)
+		}
+		return keys
+	}
+	filter := func(fn func(key string) bool) []string {
+		var keys []string
+		for _, key := range all {
+			if fn(key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	tests := []struct {
+		opts     ReadOptions
+		expected []string
+	}{
+		{ReadOptions{Prefix: []byte("tenant/12/")},
+			filter(func(key string) bool { return strings.HasPrefix(key, "tenant/12/") })},
+		{ReadOptions{LowerBound: []byte("tenant/12/0100"), UpperBound: []byte("tenant/123/0050")},
+			filter(func(key string) bool { return key >= "tenant/12/0100" && key < "tenant/123/0050" })},
+		{ReadOptions{Prefix: []byte("tenant/1"), UpperBound: []byte("tenant/12/")},
+			filter(func(key string) bool { return strings.HasPrefix(key, "tenant/1/") })},
+		{ReadOptions{LowerBound: []byte("tenant/3")}, nil},
+	}
+	for i, test := range tests {
+		opts := test.opts
+		keys := scan(&opts)
+		if strings.Join(keys, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("case %d: got %d keys, want %d", i, len(keys), len(test.expected))
+		}
+	}
+
+	// Seek到范围外面时停在边界上
+	it := db.NewIterator(&ReadOptions{Prefix: []byte("tenant/12/")})
+	defer it.Close()
+	it.Seek([]byte("tenant/1/"))
+	if !it.Valid() || string(it.Key()) != "tenant/12/0000" {
+		t.Fatalf("seek before lower bound: %v", it.Valid())
+	}
+	it.Seek([]byte("tenant/2/"))
+	if it.Valid() {
+		t.Fatalf("seek after upper bound: %s", it.Key())
+	}
+}
+
+func Test_PrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix, expected []byte
+	}{
+		{[]byte("abc"), []byte("abd")},
+		{[]byte{'a', 0xff, 0xff}, []byte("b")},
+		{[]byte{0xff}, nil},
+	}
+	for _, test := range tests {
+		if got := prefixSuccessor(test.prefix); string(got) != string(test.expected) || (got == nil) != (test.expected == nil) {
+			t.Errorf("prefixSuccessor(%q) = %q, want %q", test.prefix, got, test.expected)
+		}
+	}
+}
+
+func Test_Db_IteratorFillCache(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	blockCache := cache.NewLRUCache(1 << 20)
+	opts := &Options{BlockCache: blockCache}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		db.Put(key, key, nil)
+	}
+	db.Close()
+
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := func(readOpts *ReadOptions) int {
+		it := db.NewIterator(readOpts)
+		defer it.Close()
+		n := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+	if n := count(nil); n != 1000 || blockCache.TotalCharge() != 0 {
+		t.Fatalf("count = %d, cache charge = %d", n, blockCache.TotalCharge())
+	}
+	if n := count(&ReadOptions{FillCache: true}); n != 1000 || blockCache.TotalCharge() == 0 {
+		t.Fatalf("count = %d, cache charge = %d", n, blockCache.TotalCharge())
+	}
+	hits := blockCache.Hits()
+	if n := count(nil); n != 1000 || blockCache.Hits() == hits {
+		t.Fatalf("count = %d, hits = %d", n, blockCache.Hits())
+	}
+}
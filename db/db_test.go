@@ -2,9 +2,17 @@ package db
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/merlin82/leveldb/filter"
+	"github.com/merlin82/leveldb/internal"
 )
 
 var r = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -21,36 +29,480 @@ func GetRandomString(lenth int) []byte {
 	return result
 }
 
+func tempDbName(t *testing.T) string {
+	dbName, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbName
+}
+
 func Test_Db(t *testing.T) {
-	db := Open("D:\\leveldbtest")
-	db.Put([]byte("123"), []byte("456"))
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, _ := Open(dbName, nil)
+	db.Put([]byte("123"), []byte("456"), nil)
 
-	value, err := db.Get([]byte("123"))
+	value, err := db.Get([]byte("123"), nil)
 	fmt.Println(string(value))
 
-	db.Delete([]byte("123"))
-	value, err = db.Get([]byte("123"))
+	db.Delete([]byte("123"), nil)
+	value, err = db.Get([]byte("123"), nil)
 	fmt.Println(err)
 
-	db.Put([]byte("123"), []byte("789"))
-	value, _ = db.Get([]byte("123"))
+	db.Put([]byte("123"), []byte("789"), nil)
+	value, _ = db.Get([]byte("123"), nil)
 	fmt.Println(string(value))
 	db.Close()
 }
 
 func Test_Db2(t *testing.T) {
-	db := Open("D:\\leveldbtest")
-	db.Put([]byte("123"), []byte("456"))
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, _ := Open(dbName, nil)
+	db.Put([]byte("123"), []byte("456"), nil)
 
-	for i := 0; i < 1000000; i++ {
-		db.Put(GetRandomString(10), GetRandomString(10))
+	for i := 0; i < 10000; i++ {
+		db.Put(GetRandomString(10), GetRandomString(10), nil)
 	}
-	value, err := db.Get([]byte("123"))
+	value, err := db.Get([]byte("123"), nil)
 	fmt.Println("db:", err, string(value))
 	db.Close()
 
-	db2 := Open("D:\\leveldbtest")
-	value, err = db2.Get([]byte("123"))
+	db2, _ := Open(dbName, nil)
+	value, err = db2.Get([]byte("123"), nil)
 	fmt.Println("db reopen:", err, string(value))
 	db2.Close()
 }
+
+func Test_Db_Recover(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("123"), []byte("456"), nil)
+	db.Put([]byte("124"), []byte("457"), nil)
+	db.Delete([]byte("124"), nil)
+	db.Close()
+
+	// mem里面的数据没有写到sstable，重新打开后从wal恢复
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	value, err := db.Get([]byte("123"), nil)
+	if err != nil || string(value) != "456" {
+		t.Fatal(err, string(value))
+	}
+	if _, err = db.Get([]byte("124"), nil); err == nil {
+		t.Fatal("deleted key found")
+	}
+}
+
+func Test_Db_RecoverTornTail(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("123"), []byte("456"), nil)
+	logFileName := internal.LogFileName(dbName, db.logfileNumber)
+	db.Close()
+
+	// 模拟写wal时崩溃，最后一条记录只写了一半
+	file, err := os.OpenFile(logFileName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{1, 2, 3, 4, 5})
+	file.Close()
+
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	value, err := db.Get([]byte("123"), nil)
+	if err != nil || string(value) != "456" {
+		t.Fatal(err, string(value))
+	}
+}
+
+func Test_Db_WriteOptions(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("123"), []byte("456"), &WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("124"), []byte("457"), &WriteOptions{DisableWAL: true}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("124"), nil); err != nil || string(value) != "457" {
+		t.Fatal(err, string(value))
+	}
+	db.Close()
+
+	// 没写wal的数据还在mem里，重新打开后丢失
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get([]byte("123"), nil); err != nil || string(value) != "456" {
+		t.Fatal(err, string(value))
+	}
+	if _, err := db.Get([]byte("124"), nil); err == nil {
+		t.Fatal("unlogged key recovered")
+	}
+}
+
+func Test_Db_DeleteObsoleteFiles(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		db.Put(GetRandomString(10), GetRandomString(10), nil)
+	}
+	db.Close()
+	// 重新打开时会删除旧的wal和MANIFEST
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.mu.Lock()
+	for db.bgCompactionScheduled {
+		db.cond.Wait()
+	}
+	live := make(map[uint64]bool)
+	db.versions.AddLiveFiles(live)
+	files, _ := ioutil.ReadDir(dbName)
+	for _, f := range files {
+		number, fileType, _ := internal.ParseFileName(f.Name())
+		switch fileType {
+		case internal.TableFile:
+			if !live[number] {
+				t.Error("obsolete table file:", f.Name())
+			}
+		case internal.LogFile:
+			if number != db.logfileNumber {
+				t.Error("obsolete log file:", f.Name())
+			}
+		case internal.DescriptorFile:
+			if number != db.versions.ManifestFileNumber() {
+				t.Error("obsolete MANIFEST file:", f.Name())
+			}
+		case internal.TempFile:
+			t.Error("temp file:", f.Name())
+		}
+	}
+	db.mu.Unlock()
+}
+
+// 当前进程打开的dbName下的文件，文件被删除以后名字后面带" (deleted)"
+func openFiles(t *testing.T, dbName string) []string {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("/proc/self/fd is not available")
+	}
+	var names []string
+	for _, fd := range fds {
+		name, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(name, dbName+string(filepath.Separator)) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func Test_Db_CloseTables(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("%06d", i))
+		if err := db.Put(key, key, nil); err != nil {
+			t.Fatal(err)
+		}
+		// 读一下让sstable进入TableCache，合并以后这些sstable会被删除
+		db.Get([]byte(fmt.Sprintf("%06d", r.Intn(i+1))), nil)
+	}
+	db.mu.Lock()
+	for db.bgCompactionScheduled {
+		db.cond.Wait()
+	}
+	db.mu.Unlock()
+	for _, name := range openFiles(t, dbName) {
+		if strings.HasSuffix(name, "(deleted)") {
+			t.Error("deleted file is still open:", name)
+		}
+	}
+
+	db.Close()
+	if names := openFiles(t, dbName); len(names) > 0 {
+		t.Fatal("files still open after Close:", names)
+	}
+}
+
+func Test_Db_ReadDuringCompaction(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 后台合并的时候不持有锁，读写都可以继续
+	const numKeys = 3000
+	var written int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < numKeys; i++ {
+			key := []byte(fmt.Sprintf("%06d", i))
+			if err := db.Put(key, key, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			atomic.StoreInt64(&written, int64(i+1))
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		n := atomic.LoadInt64(&written)
+		if n == 0 {
+			continue
+		}
+		key := []byte(fmt.Sprintf("%06d", r.Int63n(n)))
+		value, err := db.Get(key, nil)
+		if err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+}
+
+func Test_Db_FlushError(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 接下来的sstable路径都是目录，imm刷盘失败
+	db.mu.Lock()
+	next := db.versions.NewFileNumber()
+	db.mu.Unlock()
+	var dirs []string
+	for number := next; number < next+100; number++ {
+		dir := internal.TableFileName(dbName, number)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	var keys [][]byte
+	for i := 0; ; i++ {
+		if i == 10000 {
+			t.Fatal("flush error is not reported")
+		}
+		key := []byte(fmt.Sprintf("%06d", i))
+		if err := db.Put(key, key, nil); err != nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	// imm没有丢掉，它的wal也还在
+	db.mu.Lock()
+	if len(db.imm) == 0 {
+		t.Error("imm dropped after failed flush")
+	}
+	for _, imm := range db.imm {
+		if _, err := os.Stat(internal.LogFileName(dbName, imm.logfileNumber)); err != nil {
+			t.Error(err)
+		}
+	}
+	db.mu.Unlock()
+	for _, key := range keys {
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+	db.Close()
+
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range keys {
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+}
+
+func Test_Db_ImmutableMemTables(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, &Options{MaxImmutableMemTables: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟后台刷盘很慢，imm一直没有持久化
+	db.mu.Lock()
+	db.bgCompactionScheduled = true
+	db.mu.Unlock()
+
+	var keys [][]byte
+	for {
+		db.mu.Lock()
+		numImm := len(db.imm)
+		db.mu.Unlock()
+		if numImm == 3 {
+			break
+		}
+		key := GetRandomString(10)
+		if err := db.Put(key, key, nil); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+
+	db.mu.Lock()
+	db.bgCompactionScheduled = false
+	db.maybeScheduleCompaction()
+	for len(db.imm) > 0 {
+		db.cond.Wait()
+	}
+	db.mu.Unlock()
+	db.Close()
+
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range keys {
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+}
+
+func Test_Db_FlushDuringCompaction(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, &Options{MaxImmutableMemTables: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// 模拟后台正在做一次很久的合并，只有合并循环里的maybeCompactMemTable会刷盘
+	db.mu.Lock()
+	db.bgCompactionScheduled = true
+	db.mu.Unlock()
+
+	done := make(chan struct{})
+	var keys [][]byte
+	// L0超过8个文件时写入会一直等合并，key的数量保证L0不会到8个
+	go func() {
+		defer close(done)
+		for i := 0; i < 80; i++ {
+			key := []byte(fmt.Sprintf("%06d", i))
+			if err := db.Put(key, key, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			keys = append(keys, key)
+		}
+	}()
+	// imm满了以后写入会一直等待，直到合并循环把imm刷盘
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(10 * time.Millisecond):
+			db.maybeCompactMemTable()
+		}
+	}
+
+	db.mu.Lock()
+	numL0 := db.versions.NumLevelFiles(0)
+	numOther := 0
+	for level := 1; level < internal.NumLevels; level++ {
+		numOther += db.versions.NumLevelFiles(level)
+	}
+	db.bgCompactionScheduled = false
+	db.mu.Unlock()
+	if numL0 == 0 || numOther != 0 {
+		t.Fatalf("level-0 files %d, other files %d", numL0, numOther)
+	}
+	for _, key := range keys {
+		if value, err := db.Get(key, nil); err != nil || string(value) != string(key) {
+			t.Fatal(string(key), err, string(value))
+		}
+	}
+}
+
+func Test_Db_FilterPolicy(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	opts := &Options{FilterPolicy: filter.NewBloomFilterPolicy(10)}
+	db, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i*2))
+		db.Put(key, key, nil)
+	}
+	db.Close()
+
+	// 重新打开以后数据都在sstable中
+	db, err = Open(dbName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i*2))
+		value, err := db.Get(key, nil)
+		if err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = %s, %v", key, value, err)
+		}
+		key = []byte(fmt.Sprintf("key%06d", i*2+1))
+		if _, err := db.Get(key, nil); err != internal.ErrNotFound {
+			t.Fatalf("Get(%s) = %v", key, err)
+		}
+	}
+}
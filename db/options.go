@@ -0,0 +1,80 @@
+package db
+
+import (
+	"github.com/merlin82/leveldb/cache"
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/filter"
+)
+
+// 打开db时的配置，nil或者零值的字段使用默认值
+type Options struct {
+	// mem写满以后转为imm等待刷盘，最多同时存在这么多个imm，超过以后写入需要等待刷盘完成
+	MaxImmutableMemTables int
+	// 不为nil时sstable中写入filter，Get时可以跳过不包含key的data block。
+	// 打开已有的db时需要使用同名的policy，否则已有的filter不会被使用
+	FilterPolicy filter.FilterPolicy
+	// 缓存sstable的data block，容量按解码后的字节数计算。
+	// nil时使用一个defaultBlockCacheSize大小的缓存，多个db可以共享同一个缓存
+	BlockCache cache.Cache
+	// sstable中block的压缩算法，nil时使用compress.Snappy，不压缩使用compress.None。
+	// 压缩后减少不到12.5%的block不压缩
+	Compressor compress.Compressor
+	// sstable的data block中每隔多少个key做一次restart，0时使用默认值16
+	BlockRestartInterval int
+	// 写入和c++版本完全一致的sstable，c++ leveldb可以直接打开这个db。
+	// wal、MANIFEST和CURRENT总是和c++版本一致，打开c++版本的db不需要设置。
+	// 这时Compressor只能是compress.None或者compress.Snappy
+	LevelDBCompatible bool
+}
+
+const (
+	defaultMaxImmutableMemTables = 2
+	defaultBlockCacheSize        = 8 << 20 // 8MB
+)
+
+// 零值的字段填充默认值
+func (opts *Options) sanitize() Options {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxImmutableMemTables <= 0 {
+		o.MaxImmutableMemTables = defaultMaxImmutableMemTables
+	}
+	if o.BlockCache == nil {
+		o.BlockCache = cache.NewLRUCache(defaultBlockCacheSize)
+	}
+	if o.Compressor == nil {
+		o.Compressor = compress.Snappy
+	}
+	return o
+}
+
+// 控制单次写入的行为，nil等同于零值
+type WriteOptions struct {
+	// 返回前对wal做fsync，机器掉电也不会丢数据；
+	// 否则只保证进程崩溃不丢数据
+	Sync bool
+	// 不写wal，用于可以重新导入的批量数据，崩溃时mem里的数据会丢失
+	DisableWAL bool
+}
+
+var defaultWriteOptions WriteOptions
+
+// 控制单次读取的行为，nil等同于零值
+type ReadOptions struct {
+	// 不为nil时读取快照时刻的数据，否则读取最新的数据
+	Snapshot *Snapshot
+	// 迭代器只返回[LowerBound, UpperBound)范围内的key，nil表示不限制
+	LowerBound []byte
+	UpperBound []byte
+	// 迭代器只返回以Prefix开头的key，和LowerBound、UpperBound同时设置时取交集
+	Prefix []byte
+	// 读取data block时校验checksum，出错时返回*CorruptionError；index等元数据block总是校验
+	VerifyChecksums bool
+	// 迭代器读到的data block是否放入block cache。默认不放入，避免一次大范围遍历把热点数据挤出缓存；
+	// Get读到的block总是放入缓存
+	FillCache bool
+}
+
+var defaultReadOptions ReadOptions
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/merlin82/leveldb/internal"
+	wal "github.com/merlin82/leveldb/log"
+	"github.com/merlin82/leveldb/memtable"
+	"github.com/merlin82/leveldb/version"
+)
+
+// 打开db时调用：
+//    1.找出编号不小于MANIFEST中记录的wal文件，按编号从小到大回放到mem，mem过大时写到L0
+//    2.创建新的wal文件，新的sstable和wal文件号一起写到MANIFEST
+func (db *Db) recover() error {
+	files, err := ioutil.ReadDir(db.name)
+	if err != nil {
+		return err
+	}
+	var logs []uint64
+	for _, f := range files {
+		number, fileType, ok := internal.ParseFileName(f.Name())
+		if ok && fileType == internal.LogFile && number >= db.versions.LogNumber() {
+			logs = append(logs, number)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	var edit version.VersionEdit
+	for _, number := range logs {
+		// 之前的wal文件号可能没有记录到MANIFEST
+		db.versions.MarkFileNumberUsed(number)
+		if err := db.replayLogFile(number, &edit); err != nil {
+			return err
+		}
+	}
+
+	if err := db.newLogFile(); err != nil {
+		return err
+	}
+	edit.SetLogNumber(db.logfileNumber)
+	return db.versions.LogAndApply(&edit)
+}
+
+// 回放生成的sstable记录到edit里面
+func (db *Db) replayLogFile(number uint64, edit *version.VersionEdit) error {
+	file, err := os.Open(internal.LogFileName(db.name, number))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var mem *memtable.MemTable
+	reader := wal.NewReader(file)
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err == wal.ErrTornTail {
+			// 上次崩溃时最后一条记录没写完，这条记录也没有返回给用户成功
+			log.Printf("log %06d: drop truncated record at tail", number)
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var batch WriteBatch
+		if err := batch.SetContents(record); err != nil {
+			return err
+		}
+		if mem == nil {
+			mem = memtable.New()
+		}
+		if err := batch.insertInto(mem); err != nil {
+			return err
+		}
+		lastSeq := batch.sequence() + uint64(batch.Len()) - 1
+		if lastSeq > db.versions.LastSequence() {
+			db.versions.SetLastSequence(lastSeq)
+		}
+		if mem.ApproximateMemoryUsage() > internal.Write_buffer_size {
+			if err := db.versions.Current().WriteLevel0Table(mem, db.versions.NewFileNumber(), edit, internal.MaxMemCompactLevel); err != nil {
+				return err
+			}
+			mem = nil
+		}
+	}
+	if mem != nil {
+		return db.versions.Current().WriteLevel0Table(mem, db.versions.NewFileNumber(), edit, internal.MaxMemCompactLevel)
+	}
+	return nil
+}
+
+// 切换到新的wal文件
+// REQUIRES: db.mu is held
+func (db *Db) newLogFile() error {
+	number := db.versions.NewFileNumber()
+	file, err := os.Create(internal.LogFileName(db.name, number))
+	if err != nil {
+		return err
+	}
+	if db.logfile != nil {
+		db.logfile.Close()
+	}
+	db.logfileNumber = number
+	db.logfile = file
+	db.log = wal.NewWriter(file)
+	return nil
+}
@@ -0,0 +1,57 @@
+package db
+
+// 某一时刻db的只读视图，通过ReadOptions.Snapshot读取时只能看到sequence不大于它的记录
+// 用完以后需要ReleaseSnapshot
+type Snapshot struct {
+	sequence   uint64
+	prev, next *Snapshot
+}
+
+// 所有没有释放的快照，按sequence从小到大组成双向链表
+type snapshotList struct {
+	head Snapshot // 链表头
+}
+
+func (list *snapshotList) init() {
+	list.head.next = &list.head
+	list.head.prev = &list.head
+}
+
+func (list *snapshotList) empty() bool {
+	return list.head.next == &list.head
+}
+
+// REQUIRES: !empty()
+func (list *snapshotList) oldest() *Snapshot {
+	return list.head.next
+}
+
+// sequence只会递增，新的快照直接加到链表尾部
+func (list *snapshotList) add(seq uint64) *Snapshot {
+	s := &Snapshot{sequence: seq}
+	s.prev = list.head.prev
+	s.next = &list.head
+	s.prev.next = s
+	s.next.prev = s
+	return s
+}
+
+func (list *snapshotList) remove(s *Snapshot) {
+	s.prev.next = s.next
+	s.next.prev = s.prev
+	s.next = nil
+	s.prev = nil
+}
+
+// 获取当前时刻的快照
+func (db *Db) GetSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.snapshots.add(db.versions.LastSequence())
+}
+
+func (db *Db) ReleaseSnapshot(s *Snapshot) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.snapshots.remove(s)
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_Db_Snapshot(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Put([]byte("a"), []byte("1"), nil)
+	db.Put([]byte("b"), []byte("1"), nil)
+	snapshot := db.GetSnapshot()
+	db.Put([]byte("a"), []byte("2"), nil)
+	db.Delete([]byte("b"), nil)
+	db.Put([]byte("c"), []byte("2"), nil)
+
+	opts := &ReadOptions{Snapshot: snapshot}
+	if value, err := db.Get([]byte("a"), opts); err != nil || string(value) != "1" {
+		t.Fatalf("snapshot get a: %s %v", value, err)
+	}
+	if value, err := db.Get([]byte("b"), opts); err != nil || string(value) != "1" {
+		t.Fatalf("snapshot get b: %s %v", value, err)
+	}
+	if _, err := db.Get([]byte("c"), opts); err == nil {
+		t.Fatalf("snapshot get c: found")
+	}
+	if value, err := db.Get([]byte("a"), nil); err != nil || string(value) != "2" {
+		t.Fatalf("get a: %s %v", value, err)
+	}
+
+	it := db.NewIterator(opts)
+	var got string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got += string(it.Key()) + "=" + string(it.Value()) + " "
+	}
+	it.Close()
+	if got != "a=1 b=1 " {
+		t.Fatalf("snapshot iterator: %s", got)
+	}
+
+	db.ReleaseSnapshot(snapshot)
+	db.mu.Lock()
+	empty := db.snapshots.empty()
+	db.mu.Unlock()
+	if !empty {
+		t.Fatalf("snapshot not released")
+	}
+}
+
+func Test_Db_SnapshotDuringCompaction(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		db.Put(key, []byte("old"), nil)
+	}
+	snapshot := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapshot)
+
+	// 覆盖和删除触发多次合并，快照能看到的旧版本不能被丢掉
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("%05d", i))
+			if i%2 == 0 {
+				db.Delete(key, nil)
+			} else {
+				db.Put(key, []byte(fmt.Sprintf("new-%d", round)), nil)
+			}
+		}
+	}
+	db.mu.Lock()
+	for db.bgCompactionScheduled {
+		db.cond.Wait()
+	}
+	db.mu.Unlock()
+
+	opts := &ReadOptions{Snapshot: snapshot}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		if value, err := db.Get(key, opts); err != nil || string(value) != "old" {
+			t.Fatalf("snapshot get %s: %s %v", key, value, err)
+		}
+		value, err := db.Get(key, nil)
+		if i%2 == 0 && err == nil {
+			t.Fatalf("get %s: deleted key found", key)
+		}
+		if i%2 == 1 && (err != nil || string(value) != "new-9") {
+			t.Fatalf("get %s: %s %v", key, value, err)
+		}
+	}
+}
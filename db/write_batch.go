@@ -0,0 +1,157 @@
+package db
+
+import (
+	"encoding/binary"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/memtable"
+)
+
+// WriteBatch内部格式，和c++版本一致：
+//    | seq 8B | count 4B | record | record | ...
+// record :=
+//    TypeValue    | varint32 key长度 | key | varint32 value长度 | value
+//    TypeDeletion | varint32 key长度 | key
+// 整个batch作为一条记录写入wal，seq是第一条record的lsn，后面的record依次加1
+const writeBatchHeaderSize = 12
+
+type WriteBatch struct {
+	rep []byte
+}
+
+func NewWriteBatch() *WriteBatch {
+	var batch WriteBatch
+	batch.Clear()
+	return &batch
+}
+
+func (batch *WriteBatch) Put(key, value []byte) {
+	batch.init()
+	batch.setCount(batch.Len() + 1)
+	batch.rep = append(batch.rep, byte(internal.TypeValue))
+	batch.appendBytes(key)
+	batch.appendBytes(value)
+}
+
+func (batch *WriteBatch) Delete(key []byte) {
+	batch.init()
+	batch.setCount(batch.Len() + 1)
+	batch.rep = append(batch.rep, byte(internal.TypeDeletion))
+	batch.appendBytes(key)
+}
+
+func (batch *WriteBatch) Clear() {
+	batch.rep = make([]byte, writeBatchHeaderSize)
+}
+
+// batch里面的记录数
+func (batch *WriteBatch) Len() int {
+	if len(batch.rep) < writeBatchHeaderSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(batch.rep[8:]))
+}
+
+// 序列化后的内容，可以通过SetContents还原
+func (batch *WriteBatch) Contents() []byte {
+	batch.init()
+	return batch.rep
+}
+
+func (batch *WriteBatch) SetContents(p []byte) error {
+	if len(p) < writeBatchHeaderSize {
+		return internal.ErrBatchCorruption
+	}
+	batch.rep = append(batch.rep[:0], p...)
+	return nil
+}
+
+// 把另一个batch的记录追加到当前batch后面
+func (batch *WriteBatch) Append(src *WriteBatch) {
+	batch.init()
+	batch.setCount(batch.Len() + src.Len())
+	if len(src.rep) > writeBatchHeaderSize {
+		batch.rep = append(batch.rep, src.rep[writeBatchHeaderSize:]...)
+	}
+}
+
+func (batch *WriteBatch) sequence() uint64 {
+	return binary.LittleEndian.Uint64(batch.rep)
+}
+
+func (batch *WriteBatch) setSequence(seq uint64) {
+	batch.init()
+	binary.LittleEndian.PutUint64(batch.rep, seq)
+}
+
+func (batch *WriteBatch) setCount(n int) {
+	binary.LittleEndian.PutUint32(batch.rep[8:], uint32(n))
+}
+
+// 零值的WriteBatch也可以直接使用
+func (batch *WriteBatch) init() {
+	if len(batch.rep) < writeBatchHeaderSize {
+		batch.Clear()
+	}
+}
+
+func (batch *WriteBatch) appendBytes(p []byte) {
+	var buf [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(buf[:], uint64(len(p)))
+	batch.rep = append(batch.rep, buf[:n]...)
+	batch.rep = append(batch.rep, p...)
+}
+
+// 依次遍历batch中的记录
+func (batch *WriteBatch) iterate(fn func(valueType internal.ValueType, key, value []byte)) error {
+	if len(batch.rep) < writeBatchHeaderSize {
+		return internal.ErrBatchCorruption
+	}
+	p := batch.rep[writeBatchHeaderSize:]
+	found := 0
+	for len(p) > 0 {
+		valueType := internal.ValueType(p[0])
+		p = p[1:]
+		var key, value []byte
+		var ok bool
+		switch valueType {
+		case internal.TypeValue:
+			if key, p, ok = readBytes(p); !ok {
+				return internal.ErrBatchCorruption
+			}
+			if value, p, ok = readBytes(p); !ok {
+				return internal.ErrBatchCorruption
+			}
+		case internal.TypeDeletion:
+			if key, p, ok = readBytes(p); !ok {
+				return internal.ErrBatchCorruption
+			}
+		default:
+			return internal.ErrBatchCorruption
+		}
+		fn(valueType, key, value)
+		found++
+	}
+	if found != batch.Len() {
+		return internal.ErrBatchCorruption
+	}
+	return nil
+}
+
+func readBytes(p []byte) ([]byte, []byte, bool) {
+	length, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) < length {
+		return nil, nil, false
+	}
+	p = p[n:]
+	return p[:length], p[length:], true
+}
+
+// 按照batch的seq依次写到mem
+func (batch *WriteBatch) insertInto(mem *memtable.MemTable) error {
+	seq := batch.sequence()
+	return batch.iterate(func(valueType internal.ValueType, key, value []byte) {
+		mem.Add(seq, valueType, key, value)
+		seq++
+	})
+}
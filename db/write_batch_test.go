@@ -0,0 +1,170 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/memtable"
+)
+
+func Test_WriteBatch(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("123"), []byte("456"))
+	batch.Delete([]byte("124"))
+	batch.Put([]byte("125"), []byte(""))
+	if batch.Len() != 3 {
+		t.Fatal(batch.Len())
+	}
+	batch.setSequence(100)
+
+	var batch2 WriteBatch
+	if err := batch2.SetContents(batch.Contents()); err != nil {
+		t.Fatal(err)
+	}
+	mem := memtable.New()
+	if err := batch2.insertInto(mem); err != nil {
+		t.Fatal(err)
+	}
+	it := mem.NewIterator()
+	it.SeekToFirst()
+	var seqs []uint64
+	for ; it.Valid(); it.Next() {
+		seqs = append(seqs, it.InternalKey().Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 100 || seqs[1] != 101 || seqs[2] != 102 {
+		t.Fatal(seqs)
+	}
+	if _, err := mem.Get(internal.LookupKey([]byte("124"), math.MaxUint64)); err != internal.ErrDeletion {
+		t.Fatal(err)
+	}
+
+	batch.Clear()
+	if batch.Len() != 0 {
+		t.Fatal(batch.Len())
+	}
+
+	// 截断的batch
+	if err := batch2.SetContents(batch2.Contents()[:len(batch2.Contents())-1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch2.insertInto(memtable.New()); err != internal.ErrBatchCorruption {
+		t.Fatal(err)
+	}
+}
+
+func Test_Db_Write(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("index/456"), []byte("123"), nil)
+
+	var batch WriteBatch
+	batch.Put([]byte("row/123"), []byte("789"))
+	batch.Put([]byte("index/789"), []byte("123"))
+	batch.Delete([]byte("index/456"))
+	if err := db.Write(&batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	if db.versions.LastSequence() != 4 {
+		t.Fatal(db.versions.LastSequence())
+	}
+	db.Close()
+
+	db, err = Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get([]byte("row/123"), nil); err != nil || string(value) != "789" {
+		t.Fatal(err, string(value))
+	}
+	if value, err := db.Get([]byte("index/789"), nil); err != nil || string(value) != "123" {
+		t.Fatal(err, string(value))
+	}
+	if _, err := db.Get([]byte("index/456"), nil); err == nil {
+		t.Fatal("deleted key found")
+	}
+	if db.versions.LastSequence() != 4 {
+		t.Fatal(db.versions.LastSequence())
+	}
+}
+
+func Test_Db_ConcurrentWrite(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const numWriters, numKeys = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < numKeys; j++ {
+				var batch WriteBatch
+				batch.Put([]byte(fmt.Sprintf("row/%d/%d", i, j)), []byte("1"))
+				batch.Put([]byte(fmt.Sprintf("index/%d/%d", i, j)), []byte("1"))
+				if err := db.Write(&batch, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	db.mu.Lock()
+	lastSeq := db.versions.LastSequence()
+	db.mu.Unlock()
+	if lastSeq != numWriters*numKeys*2 {
+		t.Fatal(lastSeq)
+	}
+	for i := 0; i < numWriters; i++ {
+		for j := 0; j < numKeys; j++ {
+			if _, err := db.Get([]byte(fmt.Sprintf("index/%d/%d", i, j)), nil); err != nil {
+				t.Fatal(i, j, err)
+			}
+		}
+	}
+}
+
+func Test_Db_WriteWALError(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	db, err := Open(dbName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写wal失败以后，之后的写入都返回同一个错误，sequence不会被重复使用
+	db.mu.Lock()
+	db.logfile.Close()
+	db.mu.Unlock()
+	err = db.Put([]byte("b"), []byte("2"), nil)
+	if err == nil {
+		t.Fatal("write to closed wal succeeded")
+	}
+	if err2 := db.Put([]byte("c"), []byte("3"), nil); err2 != err {
+		t.Fatalf("Put = %v, want %v", err2, err)
+	}
+	db.mu.Lock()
+	lastSeq := db.versions.LastSequence()
+	db.mu.Unlock()
+	if lastSeq != 1 {
+		t.Fatal(lastSeq)
+	}
+}
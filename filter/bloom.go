@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"encoding/binary"
+)
+
+// 和c++版本的布隆过滤器格式一致
+type bloomFilterPolicy struct {
+	bitsPerKey int
+	k          uint8 // hash函数的个数
+}
+
+// 每个key使用bitsPerKey个bit，10个bit时误判率大约1%
+func NewBloomFilterPolicy(bitsPerKey int) FilterPolicy {
+	// We intentionally round down to reduce probing cost a little bit
+	k := int(float64(bitsPerKey) * 0.69) // 0.69 =~ ln(2)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	return &bloomFilterPolicy{bitsPerKey: bitsPerKey, k: uint8(k)}
+}
+
+func (policy *bloomFilterPolicy) Name() string {
+	return "leveldb.BuiltinBloomFilter2"
+}
+
+func (policy *bloomFilterPolicy) CreateFilter(keys [][]byte) []byte {
+	// Compute bloom filter size (in both bits and bytes)
+	bits := len(keys) * policy.bitsPerKey
+	// For small n, we can see a very high false positive rate.  Fix it
+	// by enforcing a minimum bloom filter length.
+	if bits < 64 {
+		bits = 64
+	}
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	filter := make([]byte, bytes+1)
+	filter[bytes] = policy.k // Remember # of probes in filter
+	for _, key := range keys {
+		// Use double-hashing to generate a sequence of hash values.
+		// See analysis in [Kirsch,Mitzenmacher 2006].
+		h := bloomHash(key)
+		delta := h>>17 | h<<15 // Rotate right 17 bits
+		for j := uint8(0); j < policy.k; j++ {
+			bitpos := h % uint32(bits)
+			filter[bitpos/8] |= 1 << (bitpos % 8)
+			h += delta
+		}
+	}
+	return filter
+}
+
+func (policy *bloomFilterPolicy) KeyMayMatch(key, filter []byte) bool {
+	if len(filter) < 2 {
+		return false
+	}
+	bits := uint32(len(filter)-1) * 8
+
+	// Use the encoded k so that we can read filters generated by
+	// bloom filters created using different parameters.
+	k := filter[len(filter)-1]
+	if k > 30 {
+		// Reserved for potentially new encodings for short bloom filters.
+		// Consider it a match.
+		return true
+	}
+
+	h := bloomHash(key)
+	delta := h>>17 | h<<15 // Rotate right 17 bits
+	for j := uint8(0); j < k; j++ {
+		bitpos := h % bits
+		if filter[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// c++版本的Hash(data, n, 0xbc9f1d34)，类似murmur hash
+func bloomHash(data []byte) uint32 {
+	const (
+		seed = 0xbc9f1d34
+		m    = 0xc6a4a793
+		r    = 24
+	)
+	h := uint32(seed) ^ uint32(len(data))*m
+
+	// Pick up four bytes at a time
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data)
+		h *= m
+		h ^= h >> 16
+	}
+
+	// Pick up remaining bytes
+	switch len(data) {
+	case 3:
+		h += uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(data[0])
+		h *= m
+		h ^= h >> r
+	}
+	return h
+}
@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func intKey(i int) []byte {
+	p := make([]byte, 4)
+	binary.LittleEndian.PutUint32(p, uint32(i))
+	return p
+}
+
+func Test_BloomFilter_Empty(t *testing.T) {
+	policy := NewBloomFilterPolicy(10)
+	filter := policy.CreateFilter(nil)
+	if policy.KeyMayMatch([]byte("hello"), filter) || policy.KeyMayMatch([]byte("world"), filter) {
+		t.Fatal("empty filter matches")
+	}
+}
+
+func Test_BloomFilter_Small(t *testing.T) {
+	policy := NewBloomFilterPolicy(10)
+	filter := policy.CreateFilter([][]byte{[]byte("hello"), []byte("world")})
+	if !policy.KeyMayMatch([]byte("hello"), filter) || !policy.KeyMayMatch([]byte("world"), filter) {
+		t.Fatal("false negative")
+	}
+	if policy.KeyMayMatch([]byte("x"), filter) || policy.KeyMayMatch([]byte("foo"), filter) {
+		t.Fatal("unexpected match")
+	}
+}
+
+func Test_BloomFilter_VaryingLengths(t *testing.T) {
+	policy := NewBloomFilterPolicy(10)
+	for _, n := range []int{1, 10, 100, 1000, 10000} {
+		var keys [][]byte
+		for i := 0; i < n; i++ {
+			keys = append(keys, intKey(i))
+		}
+		filter := policy.CreateFilter(keys)
+		if len(filter) > n*10/8+40 {
+			t.Fatalf("n=%d: filter too large: %d", n, len(filter))
+		}
+		// All added keys must match
+		for i := 0; i < n; i++ {
+			if !policy.KeyMayMatch(intKey(i), filter) {
+				t.Fatalf("n=%d: key %d missing", n, i)
+			}
+		}
+		// Check false positive rate
+		matches := 0
+		for i := 0; i < 10000; i++ {
+			if policy.KeyMayMatch(intKey(i+1000000000), filter) {
+				matches++
+			}
+		}
+		if rate := float64(matches) / 10000; rate > 0.02 {
+			t.Fatalf("n=%d: false positive rate %f", n, rate)
+		}
+	}
+}
+
+// c++版本Hash的测试数据
+func Test_BloomHash(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected uint32
+	}{
+		{nil, 0xbc9f1d34},
+		{[]byte{0x62}, 0xef1345c4},
+		{[]byte{0xc3, 0x97}, 0x5b663814},
+		{[]byte{0xe2, 0x99, 0xa5}, 0x323c078f},
+		{[]byte{0xe1, 0x80, 0xb9, 0x32}, 0xed21633a},
+	}
+	for _, test := range tests {
+		if got := bloomHash(test.data); got != test.expected {
+			t.Errorf("bloomHash(%x) = %x, want %x", test.data, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package filter
+
+// 根据一组key生成一段很小的数据，用来判断某个key是否可能在这组key中
+// 每个sstable按data block生成filter，Get时先查filter，可以少读很多data block
+type FilterPolicy interface {
+	// 写入sstable的metaindex中，名字不同时不会使用已有的filter
+	Name() string
+
+	// keys生成的filter
+	CreateFilter(keys [][]byte) []byte
+
+	// key在生成filter的keys中时必须返回true，不在时应该尽量返回false
+	KeyMayMatch(key, filter []byte) bool
+}
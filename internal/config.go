@@ -18,4 +18,9 @@ const (
 	MaxFileSize = 2 << 6 //
 
 	BaseLevelSize = 2 << 8
+
+	// MANIFEST超过这个大小后，下次修改时写一个新的MANIFEST
+	MaxManifestFileSize = 4 << 20 // 4MB
+	// 目前只支持按字节比较，打开db时检查和MANIFEST里面记录的是否一致
+	ComparatorName = "leveldb.BytewiseComparator"
 )
@@ -0,0 +1,26 @@
+package internal
+
+import "hash/crc32"
+
+const crcMaskDelta = 0xa282ead8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C(Castagnoli)，和c++版本保持一致
+func Crc32c(p ...[]byte) uint32 {
+	var crc uint32
+	for _, b := range p {
+		crc = crc32.Update(crc, crcTable, b)
+	}
+	return crc
+}
+
+// 数据里面如果嵌入了crc，再对整体计算crc容易出问题，所以存储的crc需要做一次变换
+func MaskCrc(crc uint32) uint32 {
+	return ((crc >> 15) | (crc << 17)) + crcMaskDelta
+}
+
+func UnmaskCrc(masked uint32) uint32 {
+	rot := masked - crcMaskDelta
+	return (rot >> 17) | (rot << 15)
+}
@@ -2,11 +2,35 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
-	ErrNotFound          = errors.New("Not Found")
-	ErrDeletion          = errors.New("Type Deletion")
-	ErrTableFileMagic    = errors.New("not an sstable (bad magic number)")
-	ErrTableFileTooShort = errors.New("file is too short to be an sstable")
+	ErrNotFound              = errors.New("Not Found")
+	ErrDeletion              = errors.New("Type Deletion")
+	ErrTableFileMagic        = errors.New("not an sstable (bad magic number)")
+	ErrTableFileTooShort     = errors.New("file is too short to be an sstable")
+	ErrTableFormatVersion    = errors.New("unsupported sstable format version")
+	ErrTableFooterCorruption = errors.New("bad block handle in sstable footer")
+	ErrTableFileTooLarge     = errors.New("sstable too large for its format version")
+	ErrBatchCorruption       = errors.New("malformed WriteBatch")
+	ErrEditCorruption        = errors.New("malformed VersionEdit")
+	ErrBlockCorruption       = errors.New("bad entry in block")
+	ErrUnsupportedCompressor = errors.New("compressor is not supported by LevelDB")
 )
+
+// 文件中读到损坏的数据，比如checksum不一致、block被截断
+type CorruptionError struct {
+	File   string
+	Offset uint64 // 损坏的数据在文件中的偏移量
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corruption: %s (file %s, offset %d)", e.Reason, e.File, e.Offset)
+}
+
+func IsCorruption(err error) bool {
+	_, ok := err.(*CorruptionError)
+	return ok
+}
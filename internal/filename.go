@@ -2,16 +2,37 @@ package internal
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+)
+
+type FileType int
+
+const (
+	LogFile FileType = iota
+	TableFile
+	DescriptorFile
+	CurrentFile
+	TempFile
 )
 
 func makeFileName(dbname string, number uint64, suffix string) string {
 	return fmt.Sprintf("%s/%06d.%s", dbname, number, suffix)
 }
 
+func LogFileName(dbname string, number uint64) string {
+	return makeFileName(dbname, number, "log")
+}
+
 func TableFileName(dbname string, number uint64) string {
 	return makeFileName(dbname, number, "ldb")
 }
 
+// c++版本以前使用的sstable文件名，只在打开时兼容
+func SSTTableFileName(dbname string, number uint64) string {
+	return makeFileName(dbname, number, "sst")
+}
+
 func DescriptorFileName(dbname string, number uint64) string {
 	return fmt.Sprintf("%s/MANIFEST-%06d", dbname, number)
 }
@@ -22,3 +43,37 @@ func CurrentFileName(dbname string) string {
 func TempFileName(dbname string, number uint64) string {
 	return makeFileName(dbname, number, "dbtmp")
 }
+
+// 根据文件名(不含目录)解析出文件类型和文件号，不认识的文件返回false
+//    CURRENT
+//    MANIFEST-[0-9]+
+//    [0-9]+.(log|ldb|sst|dbtmp)
+func ParseFileName(fileName string) (uint64, FileType, bool) {
+	if fileName == "CURRENT" {
+		return 0, CurrentFile, true
+	}
+	if strings.HasPrefix(fileName, "MANIFEST-") {
+		number, err := strconv.ParseUint(strings.TrimPrefix(fileName, "MANIFEST-"), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return number, DescriptorFile, true
+	}
+	pos := strings.IndexByte(fileName, '.')
+	if pos < 0 {
+		return 0, 0, false
+	}
+	number, err := strconv.ParseUint(fileName[:pos], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch fileName[pos+1:] {
+	case "log":
+		return number, LogFile, true
+	case "ldb", "sst":
+		return number, TableFile, true
+	case "dbtmp":
+		return number, TempFile, true
+	}
+	return 0, 0, false
+}
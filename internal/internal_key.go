@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
-	"math"
 )
 
 type ValueType int8
@@ -14,6 +13,9 @@ const (
 	TypeValue    ValueType = 1
 )
 
+// 编码时seq和type合在一个uint64里，seq只有56位
+const MaxSequenceNumber = (uint64(1) << 56) - 1
+
 type InternalKey struct {
 	Seq       uint64
 	Type      ValueType
@@ -55,8 +57,38 @@ func (key *InternalKey) DecodeFrom(r io.Reader) error {
 	return binary.Read(r, binary.LittleEndian, key.UserValue)
 }
 
-func LookupKey(key []byte) *InternalKey {
-	return NewInternalKey(math.MaxUint64, TypeValue, key, nil)
+// 和c++版本一致的编码方式，只包含key，不包含value：
+//    | user key | seq<<8 | type 8B |
+// MANIFEST里面记录文件的最大最小key时使用
+// LookupKey(key, math.MaxUint64)这种超过56位的seq按MaxSequenceNumber编码
+func (key *InternalKey) Encode() []byte {
+	seq := key.Seq
+	if seq > MaxSequenceNumber {
+		seq = MaxSequenceNumber
+	}
+	p := make([]byte, len(key.UserKey)+8)
+	copy(p, key.UserKey)
+	binary.LittleEndian.PutUint64(p[len(key.UserKey):], seq<<8|uint64(key.Type))
+	return p
+}
+
+// Encode的逆过程，数据不合法返回nil
+func DecodeInternalKey(p []byte) *InternalKey {
+	if len(p) < 8 {
+		return nil
+	}
+	n := len(p) - 8
+	trailer := binary.LittleEndian.Uint64(p[n:])
+	valueType := ValueType(trailer & 0xff)
+	if valueType != TypeDeletion && valueType != TypeValue {
+		return nil
+	}
+	return NewInternalKey(trailer>>8, valueType, p[:n], nil)
+}
+
+// 查找sequence不大于seq的最新记录
+func LookupKey(key []byte, seq uint64) *InternalKey {
+	return NewInternalKey(seq, TypeValue, key, nil)
 }
 
 func InternalKeyComparator(a, b interface{}) int {
@@ -79,6 +111,49 @@ func InternalKeyComparator(a, b interface{}) int {
 	return r
 }
 
+// 返回一个尽量短的key，满足start <= key < limit，sstable的index block用它作为data block的key。
+// 和c++版本的InternalKeyComparator::FindShortestSeparator结果一致
+func FindShortestSeparator(start, limit *InternalKey) *InternalKey {
+	// Find length of common prefix
+	minLength := len(start.UserKey)
+	if len(limit.UserKey) < minLength {
+		minLength = len(limit.UserKey)
+	}
+	diffIndex := 0
+	for diffIndex < minLength && start.UserKey[diffIndex] == limit.UserKey[diffIndex] {
+		diffIndex++
+	}
+	if diffIndex < minLength {
+		diffByte := start.UserKey[diffIndex]
+		if diffByte < 0xff && diffByte+1 < limit.UserKey[diffIndex] && diffIndex+1 < len(start.UserKey) {
+			// User key has become shorter physically, but larger logically.
+			// Tack on the earliest possible number to the shortened user key.
+			userKey := append([]byte(nil), start.UserKey[:diffIndex+1]...)
+			userKey[diffIndex]++
+			return NewInternalKey(MaxSequenceNumber, TypeValue, userKey, nil)
+		}
+	}
+	// Do not shorten if one string is a prefix of the other
+	return NewInternalKey(start.Seq, start.Type, start.UserKey, nil)
+}
+
+// 返回一个尽量短的key，满足key >= 参数key，用作sstable最后一个data block的index key
+func FindShortSuccessor(key *InternalKey) *InternalKey {
+	// Find first character that can be incremented
+	for i, b := range key.UserKey {
+		if b != 0xff && i+1 < len(key.UserKey) {
+			userKey := append([]byte(nil), key.UserKey[:i+1]...)
+			userKey[i]++
+			return NewInternalKey(MaxSequenceNumber, TypeValue, userKey, nil)
+		}
+		if b != 0xff {
+			break
+		}
+	}
+	// key is a run of 0xffs.  Leave it alone.
+	return NewInternalKey(key.Seq, key.Type, key.UserKey, nil)
+}
+
 func UserKeyComparator(a, b interface{}) int {
 	aKey := a.([]byte)
 	bKey := b.([]byte)
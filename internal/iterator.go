@@ -0,0 +1,43 @@
+package internal
+
+// memtable、sstable等按InternalKeyComparator顺序遍历的迭代器，
+// 合并多个来源的数据时统一使用这个接口
+type Iterator interface {
+	// Returns true iff the iterator is positioned at a valid node.
+	Valid() bool
+
+	// Returns the key at the current position.
+	// 返回的key和value可能引用迭代器内部的数据，迭代器移动以后失效，需要保留时自己复制
+	// REQUIRES: Valid()
+	InternalKey() *InternalKey
+
+	// Advances to the next position.
+	// REQUIRES: Valid()
+	Next()
+
+	// Advances to the previous position.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Advance to the first entry with a key >= target
+	Seek(target *InternalKey)
+
+	// Position at the first entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToFirst()
+
+	// Position at the last entry in list.
+	// Final state of iterator is Valid() iff list is not empty.
+	SeekToLast()
+
+	// 遍历过程中遇到的错误，比如sstable中的block损坏。
+	// 出错的数据会被跳过，Valid()为false时需要检查Error()区分是遍历完了还是出错了
+	Error() error
+}
+
+// 迭代器用完以后释放它引用的资源，比如sstable文件；没有Close方法的迭代器不需要释放
+func CloseIterator(it Iterator) {
+	if c, ok := it.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
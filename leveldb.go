@@ -1,51 +1,89 @@
 package leveldb
 
 import (
+	"github.com/merlin82/leveldb/cache"
+	"github.com/merlin82/leveldb/compress"
 	"github.com/merlin82/leveldb/db"
+	"github.com/merlin82/leveldb/filter"
+	"github.com/merlin82/leveldb/internal"
 )
 
+// 多个key的修改原子写入
+type WriteBatch = db.WriteBatch
+
+// 打开db时的配置，传nil使用默认值
+type Options = db.Options
+
+// sstable中filter的生成规则，配置在Options.FilterPolicy
+type FilterPolicy = filter.FilterPolicy
+
+// 按字节数限制容量的lru缓存，配置在Options.BlockCache
+type Cache = cache.Cache
+
+// block的压缩算法，配置在Options.Compressor
+type Compressor = compress.Compressor
+
+// 内置的压缩算法
+var (
+	NoCompression     = compress.None
+	SnappyCompression = compress.Snappy
+	FlateCompression  = compress.Flate
+)
+
+// 单次写入的选项，传nil使用默认值
+type WriteOptions = db.WriteOptions
+
+// 单次读取的选项，传nil使用默认值
+type ReadOptions = db.ReadOptions
+
+// 读到损坏的数据时返回的错误
+type CorruptionError = internal.CorruptionError
+
+// 某一时刻db的只读视图，用完以后需要ReleaseSnapshot
+type Snapshot = db.Snapshot
+
 type LevelDb interface {
-	Put(key, value []byte) error
-	Get(key []byte) ([]byte, error)
-	Delete(key []byte) error
+	Put(key, value []byte, opts *WriteOptions) error
+	Get(key []byte, opts *ReadOptions) ([]byte, error)
+	Delete(key []byte, opts *WriteOptions) error
+	Write(batch *WriteBatch, opts *WriteOptions) error
+	NewIterator(opts *ReadOptions) Iterator
+	GetSnapshot() *Snapshot
+	ReleaseSnapshot(s *Snapshot)
 	PrintMem()
 	PrintVersion()
 }
 
-type Iterator interface {
-	// Returns true iff the iterator is positioned at a valid node.
-	Valid() bool
-
-	// Returns the key at the current position.
-	// REQUIRES: Valid()
-	Key() []byte
-
-	// Return the value for the current entry.  The underlying storage for
-	// the returned slice is valid only until the next modification of
-	// the iterator.
-	// REQUIRES: Valid()
-	Value() []byte
+// 遍历db的迭代器，用完以后需要Close
+type Iterator = db.Iterator
 
-	// Advances to the next position.
-	// REQUIRES: Valid()
-	Next()
+func Open(dbName string, opts *Options) (LevelDb, error) {
+	d, err := db.Open(dbName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
 
-	// Advances to the previous position.
-	// REQUIRES: Valid()
-	Prev()
+// 注册自定义的压缩算法，需要在Open之前调用
+func RegisterCompressor(c Compressor) {
+	compress.Register(c)
+}
 
-	// Advance to the first entry with a key >= target
-	Seek(target []byte)
+func IsCorruption(err error) bool {
+	return internal.IsCorruption(err)
+}
 
-	// Position at the first entry in list.
-	// Final state of iterator is Valid() iff list is not empty.
-	SeekToFirst()
+func NewWriteBatch() *WriteBatch {
+	return db.NewWriteBatch()
+}
 
-	// Position at the last entry in list.
-	// Final state of iterator is Valid() iff list is not empty.
-	SeekToLast()
+// 布隆过滤器，每个key使用bitsPerKey个bit，10个bit时误判率大约1%
+func NewBloomFilterPolicy(bitsPerKey int) FilterPolicy {
+	return filter.NewBloomFilterPolicy(bitsPerKey)
 }
 
-func Open(dbName string) LevelDb {
-	return db.Open(dbName)
+// 容量为capacity字节的lru缓存，可以通过Hits、Misses查看命中情况
+func NewLRUCache(capacity int) Cache {
+	return cache.NewLRUCache(capacity)
 }
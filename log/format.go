@@ -0,0 +1,31 @@
+package log
+
+import "errors"
+
+// 日志文件由连续的32KB block组成，每个block里面是若干条物理记录：
+// | checksum 4B | length 2B | type 1B | data |
+// checksum是type+data的masked crc32c。
+// 一条用户记录可能跨block，被切成FIRST/MIDDLE/LAST多个分片；没有跨block的记录是FULL。
+// block剩余空间不足7B(放不下header)时补0，下一条记录从新的block开始。
+const (
+	BlockSize  = 32 * 1024
+	HeaderSize = 4 + 2 + 1
+)
+
+type recordType byte
+
+const (
+	// 预留给预分配文件里全0的区域
+	zeroType recordType = iota
+	fullType
+	firstType
+	middleType
+	lastType
+)
+
+var (
+	// 记录校验失败，或者分片顺序不对
+	ErrCorruption = errors.New("leveldb/log: corrupted record")
+	// 文件末尾的记录只写了一部分，一般是写日志过程中进程崩溃导致
+	ErrTornTail = errors.New("leveldb/log: truncated record at end of file")
+)
@@ -0,0 +1,82 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_Log(t *testing.T) {
+	var records [][]byte
+	records = append(records, []byte("123"))
+	records = append(records, []byte{})
+	// 跨多个block的记录
+	records = append(records, []byte(strings.Repeat("a", 3*BlockSize)))
+	// 刚好让block剩余空间不足一个header
+	records = append(records, []byte(strings.Repeat("b", BlockSize-HeaderSize*2-3)))
+	records = append(records, []byte("456"))
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, record := range records {
+		if err := w.AddRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for _, record := range records {
+		p, err := r.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, record) {
+			t.Fatalf("record mismatch, len %d != %d", len(p), len(record))
+		}
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Fatal(err)
+	}
+}
+
+func Test_Log_TornTail(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.AddRecord([]byte("123"))
+	w.AddRecord([]byte(strings.Repeat("a", 2*BlockSize)))
+
+	// 第二条记录只写了一部分
+	p := buf.Bytes()
+	r := NewReader(bytes.NewReader(p[:BlockSize+100]))
+	if record, err := r.ReadRecord(); err != nil || string(record) != "123" {
+		t.Fatal(err, string(record))
+	}
+	if _, err := r.ReadRecord(); err != ErrTornTail {
+		t.Fatal(err)
+	}
+
+	// header只写了一部分
+	r = NewReader(bytes.NewReader(p[:HeaderSize+3+2]))
+	r.ReadRecord()
+	if _, err := r.ReadRecord(); err != ErrTornTail {
+		t.Fatal(err)
+	}
+}
+
+func Test_Log_Corruption(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.AddRecord([]byte("123"))
+	w.AddRecord([]byte("456"))
+
+	p := buf.Bytes()
+	p[HeaderSize+3+HeaderSize] ^= 0xff
+	r := NewReader(bytes.NewReader(p))
+	if record, err := r.ReadRecord(); err != nil || string(record) != "123" {
+		t.Fatal(err, string(record))
+	}
+	if _, err := r.ReadRecord(); err != ErrCorruption {
+		t.Fatal(err)
+	}
+}
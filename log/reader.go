@@ -0,0 +1,117 @@
+package log
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+type Reader struct {
+	r   io.Reader
+	buf [BlockSize]byte
+	// buf[i:j]是当前block还没有解析的部分
+	i, j int
+	// 最后一个block不满32KB，说明已经读到文件末尾
+	eof bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// 读取下一条完整记录，返回的数据调用方可以直接持有
+// 读完返回io.EOF；文件末尾只写了一半的记录返回ErrTornTail；校验失败返回ErrCorruption
+func (r *Reader) ReadRecord() ([]byte, error) {
+	var record []byte
+	inFragmentedRecord := false
+	for {
+		t, fragment, err := r.readPhysicalRecord()
+		if err == io.EOF && inFragmentedRecord {
+			// 只写了FIRST/MIDDLE，LAST还没写进去
+			return nil, ErrTornTail
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case fullType:
+			if inFragmentedRecord {
+				return nil, ErrCorruption
+			}
+			return append([]byte(nil), fragment...), nil
+		case firstType:
+			if inFragmentedRecord {
+				return nil, ErrCorruption
+			}
+			record = append(record[:0], fragment...)
+			inFragmentedRecord = true
+		case middleType:
+			if !inFragmentedRecord {
+				return nil, ErrCorruption
+			}
+			record = append(record, fragment...)
+		case lastType:
+			if !inFragmentedRecord {
+				return nil, ErrCorruption
+			}
+			return append(record, fragment...), nil
+		default:
+			return nil, ErrCorruption
+		}
+	}
+}
+
+func (r *Reader) readPhysicalRecord() (recordType, []byte, error) {
+	for {
+		if r.j-r.i < HeaderSize {
+			if r.eof {
+				if r.j > r.i {
+					// 文件末尾的header不完整
+					return 0, nil, ErrTornTail
+				}
+				return 0, nil, io.EOF
+			}
+			// 剩余的是block尾部补的0，直接读下一个block
+			if err := r.readBlock(); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		header := r.buf[r.i : r.i+HeaderSize]
+		length := int(binary.LittleEndian.Uint16(header[4:6]))
+		t := recordType(header[6])
+		if t == zeroType && length == 0 {
+			// 预分配的空白区域，跳过当前block剩余部分
+			r.i = r.j
+			continue
+		}
+		if HeaderSize+length > r.j-r.i {
+			if r.eof {
+				return 0, nil, ErrTornTail
+			}
+			return 0, nil, ErrCorruption
+		}
+
+		data := r.buf[r.i+HeaderSize : r.i+HeaderSize+length]
+		expected := internal.UnmaskCrc(binary.LittleEndian.Uint32(header[0:4]))
+		if internal.Crc32c(header[6:7], data) != expected {
+			return 0, nil, ErrCorruption
+		}
+		r.i += HeaderSize + length
+		return t, data, nil
+	}
+}
+
+func (r *Reader) readBlock() error {
+	n, err := io.ReadFull(r.r, r.buf[:])
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		return err
+	}
+	r.i, r.j = 0, n
+	return nil
+}
@@ -0,0 +1,77 @@
+package log
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+type Writer struct {
+	w           io.Writer
+	blockOffset int // 当前block已经写了多少字节
+	buf         []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// 追加到已有的日志文件，size为文件当前大小
+func NewWriterAt(w io.Writer, size int64) *Writer {
+	return &Writer{w: w, blockOffset: int(size % BlockSize)}
+}
+
+// 一条记录按block切成若干分片，所有分片拼好以后一次write写下去，不做fsync
+func (w *Writer) AddRecord(p []byte) error {
+	w.buf = w.buf[:0]
+	begin := true
+	for {
+		leftover := BlockSize - w.blockOffset
+		if leftover < HeaderSize {
+			// block剩余空间放不下header，补0切到下一个block
+			for i := 0; i < leftover; i++ {
+				w.buf = append(w.buf, 0)
+			}
+			w.blockOffset = 0
+		}
+
+		avail := BlockSize - w.blockOffset - HeaderSize
+		n := len(p)
+		if n > avail {
+			n = avail
+		}
+		end := n == len(p)
+
+		var t recordType
+		switch {
+		case begin && end:
+			t = fullType
+		case begin:
+			t = firstType
+		case end:
+			t = lastType
+		default:
+			t = middleType
+		}
+		w.appendPhysicalRecord(t, p[:n])
+		p = p[n:]
+		begin = false
+		if end {
+			break
+		}
+	}
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+func (w *Writer) appendPhysicalRecord(t recordType, p []byte) {
+	var header [HeaderSize]byte
+	crc := internal.Crc32c([]byte{byte(t)}, p)
+	binary.LittleEndian.PutUint32(header[0:4], internal.MaskCrc(crc))
+	binary.LittleEndian.PutUint16(header[4:6], uint16(len(p)))
+	header[6] = byte(t)
+	w.buf = append(w.buf, header[:]...)
+	w.buf = append(w.buf, p...)
+	w.blockOffset += HeaderSize + len(p)
+}
@@ -31,7 +31,7 @@ func (it *Iterator) Prev() {
 }
 
 // Advance to the first entry with a key >= target
-func (it *Iterator) Seek(target interface{}) {
+func (it *Iterator) Seek(target *internal.InternalKey) {
 	it.listIter.Seek(target)
 }
 
@@ -46,3 +46,8 @@ func (it *Iterator) SeekToFirst() {
 func (it *Iterator) SeekToLast() {
 	it.listIter.SeekToLast()
 }
+
+// 内存中的数据不会出错
+func (it *Iterator) Error() error {
+	return nil
+}
@@ -27,14 +27,12 @@ func (memTable *MemTable) Add(seq uint64, valueType internal.ValueType, key, val
 	memTable.table.Insert(internalKey)
 }
 
-func (memTable *MemTable) Get(key []byte) ([]byte, error) {
-	lookupKey := internal.LookupKey(key)
-
+func (memTable *MemTable) Get(lookupKey *internal.InternalKey) ([]byte, error) {
 	it := memTable.table.NewIterator()
 	it.Seek(lookupKey)
 	if it.Valid() {
 		internalKey := it.Key().(*internal.InternalKey)
-		if internal.UserKeyComparator(key, internalKey.UserKey) == 0 {
+		if internal.UserKeyComparator(lookupKey.UserKey, internalKey.UserKey) == 0 {
 			// 判断valueType
 			if internalKey.Type == internal.TypeValue {
 				return internalKey.UserValue, nil
@@ -2,6 +2,7 @@ package memtable
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/merlin82/leveldb/internal"
@@ -11,7 +12,7 @@ func Test_MemTable(t *testing.T) {
 	memTable := New()
 	memTable.Add(1234567, internal.TypeValue, []byte("aadsa34a"), []byte("bb23b3423"))
 	memTable.Add(1234567, internal.TypeValue, []byte("aadsa34a"), []byte("bb23b34232"))
-	value, _ := memTable.Get([]byte("aadsa34a"))
+	value, _ := memTable.Get(internal.LookupKey([]byte("aadsa34a"), math.MaxUint64))
 	fmt.Println(string(value))
 	fmt.Println(memTable.ApproximateMemoryUsage())
 }
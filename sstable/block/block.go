@@ -1,34 +1,72 @@
 package block
 
 import (
-	"bytes"
 	"encoding/binary"
-
-	"github.com/merlin82/leveldb/internal"
+	"math"
 )
 
+// 只保存block的原始数据，遍历时再解码entry，不需要的entry不会被解码
 type Block struct {
-	items []internal.InternalKey
+	data          []byte
+	restartOffset int // restart数组在data中的偏移量
+	numRestarts   int
 }
 
+// p的格式不对时返回nil，entry的内容在遍历时才检查
+// 返回的block引用p，调用方不能再修改p
 func New(p []byte) *Block {
+	if len(p) < 4 {
+		return nil
+	}
+	numRestarts := binary.LittleEndian.Uint32(p[len(p)-4:])
+	maxRestarts := uint32(len(p)-4) / 4
+	if numRestarts > maxRestarts {
+		return nil
+	}
+	return &Block{
+		data:          p,
+		restartOffset: len(p) - 4 - 4*int(numRestarts),
+		numRestarts:   int(numRestarts),
+	}
+}
 
-	data := bytes.NewBuffer(p)
-	counter := binary.LittleEndian.Uint32(p[len(p)-4:])
+func (block *Block) restartPoint(index int) int {
+	return int(binary.LittleEndian.Uint32(block.data[block.restartOffset+4*index:]))
+}
 
-	var block Block
-	for i := uint32(0); i < counter; i++ {
-		var item internal.InternalKey
-		err := item.DecodeFrom(data)
-		if err != nil {
-			return nil
+// 解析entry的头部，返回头部占用的字节数，数据不合法时返回0
+func decodeEntry(p []byte) (shared, nonShared, valueLength, n int) {
+	var v [3]uint64
+	for i := range v {
+		x, m := binary.Uvarint(p[n:])
+		if m <= 0 || x > math.MaxInt32 {
+			return 0, 0, 0, 0
 		}
-		block.items = append(block.items, item)
+		v[i] = x
+		n += m
 	}
+	return int(v[0]), int(v[1]), int(v[2]), n
+}
 
-	return &block
+// 占用的内存，放入block cache时按这个大小计算容量
+func (block *Block) Size() int {
+	return len(block.data)
 }
 
 func (block *Block) NewIterator() *Iterator {
-	return &Iterator{block: block}
+	it := &Iterator{
+		block:        block,
+		current:      block.restartOffset,
+		restartIndex: block.numRestarts,
+	}
+	it.keyBuf = it.scratch[:0]
+	return it
+}
+
+// block中的key不是internal key时使用，比如c++版本的metaindex block。
+// InternalKey().UserKey是完整的key，Seek时只比较target的UserKey
+func (block *Block) NewRawIterator() *Iterator {
+	it := block.NewIterator()
+	it.raw = true
+	return it
 }
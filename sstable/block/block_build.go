@@ -7,28 +7,85 @@ import (
 	"github.com/merlin82/leveldb/internal"
 )
 
+const defaultRestartInterval = 16
+
+// 和c++版本一致的block格式，key和前一个key相同的前缀只保存一次：
+//    entry: | shared varint | non_shared varint | value_length varint | key_delta | value |
+//    trailer: | restarts uint32 * num_restarts | num_restarts uint32 |
+// 每隔RestartInterval个key做一次restart，restart处的key完整保存(shared == 0)，
+// restarts记录这些entry的偏移量，查找时先在restart point上二分
 type BlockBuilder struct {
-	buf     bytes.Buffer
-	counter uint32
+	RestartInterval int // 0时使用defaultRestartInterval
+
+	buf      bytes.Buffer
+	restarts []uint32
+	counter  int    // 距离上一个restart point的entry数
+	lastKey  []byte // 上一个key的编码
 }
 
 func (blockBuilder *BlockBuilder) Reset() {
-	blockBuilder.counter = 0
 	blockBuilder.buf.Reset()
+	blockBuilder.restarts = blockBuilder.restarts[:0]
+	blockBuilder.counter = 0
+	blockBuilder.lastKey = blockBuilder.lastKey[:0]
 }
 
+// REQUIRES: item比之前加入的key都大
 func (blockBuilder *BlockBuilder) Add(item *internal.InternalKey) error {
+	blockBuilder.AddKeyValue(item.Encode(), item.UserValue)
+	return nil
+}
+
+// key不是internal key时使用，比如c++版本metaindex block中的key
+// REQUIRES: key按字节序比之前加入的key都大
+func (blockBuilder *BlockBuilder) AddKeyValue(key, value []byte) {
+	interval := blockBuilder.RestartInterval
+	if interval <= 0 {
+		interval = defaultRestartInterval
+	}
+	shared := 0
+	if blockBuilder.buf.Len() == 0 || blockBuilder.counter >= interval {
+		// Restart compression
+		blockBuilder.restarts = append(blockBuilder.restarts, uint32(blockBuilder.buf.Len()))
+		blockBuilder.counter = 0
+	} else {
+		// See how much sharing to do with previous string
+		for shared < len(key) && shared < len(blockBuilder.lastKey) && key[shared] == blockBuilder.lastKey[shared] {
+			shared++
+		}
+	}
+
+	// Add "<shared><non_shared><value_size>" to buffer
+	var tmp [3 * binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(tmp[:], uint64(shared))
+	n += binary.PutUvarint(tmp[n:], uint64(len(key)-shared))
+	n += binary.PutUvarint(tmp[n:], uint64(len(value)))
+	blockBuilder.buf.Write(tmp[:n])
+
+	// Add string delta to buffer followed by value
+	blockBuilder.buf.Write(key[shared:])
+	blockBuilder.buf.Write(value)
+
+	blockBuilder.lastKey = append(blockBuilder.lastKey[:0], key...)
 	blockBuilder.counter++
-	return item.EncodeTo(&blockBuilder.buf)
 }
 
 func (blockBuilder *BlockBuilder) Finish() []byte {
-	binary.Write(&blockBuilder.buf, binary.LittleEndian, blockBuilder.counter)
+	// 空block也有一个restart point
+	if len(blockBuilder.restarts) == 0 {
+		blockBuilder.restarts = append(blockBuilder.restarts, 0)
+	}
+	for _, restart := range blockBuilder.restarts {
+		binary.Write(&blockBuilder.buf, binary.LittleEndian, restart)
+	}
+	binary.Write(&blockBuilder.buf, binary.LittleEndian, uint32(len(blockBuilder.restarts)))
 	return blockBuilder.buf.Bytes()
 }
 
 func (blockBuilder *BlockBuilder) CurrentSizeEstimate() int {
-	return blockBuilder.buf.Len()
+	return blockBuilder.buf.Len() + // Raw data buffer
+		len(blockBuilder.restarts)*4 + // Restart array
+		4 // Restart array length
 }
 
 func (blockBuilder *BlockBuilder) Empty() bool {
@@ -1,6 +1,9 @@
 package block
 
 import (
+	"bytes"
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/merlin82/leveldb/internal"
@@ -20,9 +23,9 @@ func Test_SsTable(t *testing.T) {
 	block := New(p)
 	it := block.NewIterator()
 
-	it.Seek([]byte("124"))
+	it.Seek(internal.LookupKey([]byte("124"), math.MaxUint64))
 	if it.Valid() {
-		if string(it.InternalKey().UserKey) != "1245" {
+		if string(it.InternalKey().UserValue) != "1245" {
 			t.Fail()
 		}
 
@@ -30,3 +33,164 @@ func Test_SsTable(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func testItems(n int) []*internal.InternalKey {
+	var items []*internal.InternalKey
+	for i := 0; i < n; i++ {
+		// 同一个user key的多个版本，seq大的在前面
+		key := []byte(fmt.Sprintf("user/profile/%06d", i/2))
+		items = append(items, internal.NewInternalKey(uint64(n-i), internal.TypeValue, key, []byte(fmt.Sprintf("value%d", i))))
+	}
+	return items
+}
+
+func Test_Block_RestartInterval(t *testing.T) {
+	items := testItems(100)
+	for _, interval := range []int{0, 1, 2, 16, 1000} {
+		builder := BlockBuilder{RestartInterval: interval}
+		for _, item := range items {
+			builder.Add(item)
+		}
+		block := New(builder.Finish())
+		if block == nil {
+			t.Fatalf("interval %d: bad block", interval)
+		}
+
+		// 正向、反向遍历
+		it := block.NewIterator()
+		i := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if internal.InternalKeyComparator(it.InternalKey(), items[i]) != 0 || !bytes.Equal(it.InternalKey().UserValue, items[i].UserValue) {
+				t.Fatalf("interval %d: entry %d mismatch", interval, i)
+			}
+			i++
+		}
+		if i != len(items) {
+			t.Fatalf("interval %d: %d entries", interval, i)
+		}
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+			i--
+			if internal.InternalKeyComparator(it.InternalKey(), items[i]) != 0 {
+				t.Fatalf("interval %d: entry %d mismatch", interval, i)
+			}
+		}
+
+		// 每个key都能Seek到
+		for i, item := range items {
+			it.Seek(item)
+			if !it.Valid() || !bytes.Equal(it.InternalKey().UserValue, items[i].UserValue) {
+				t.Fatalf("interval %d: seek %d failed", interval, i)
+			}
+		}
+		// 不存在的key定位到下一个
+		it.Seek(internal.LookupKey([]byte("user/profile/000010a"), math.MaxUint64))
+		if !it.Valid() || string(it.InternalKey().UserKey) != "user/profile/000011" {
+			t.Fatalf("interval %d: seek missing key failed", interval)
+		}
+		it.Seek(internal.LookupKey([]byte("user/profile/999999"), math.MaxUint64))
+		if it.Valid() {
+			t.Fatalf("interval %d: seek past end is valid", interval)
+		}
+		it.Seek(internal.LookupKey([]byte("a"), math.MaxUint64))
+		if !it.Valid() || internal.InternalKeyComparator(it.InternalKey(), items[0]) != 0 {
+			t.Fatalf("interval %d: seek before start failed", interval)
+		}
+	}
+}
+
+// 相同的前缀只保存一次
+func Test_Block_PrefixCompression(t *testing.T) {
+	items := testItems(100)
+	var builder BlockBuilder
+	raw := 0
+	for _, item := range items {
+		builder.Add(item)
+		raw += len(item.UserKey) + 8 + len(item.UserValue)
+	}
+	if size := len(builder.Finish()); size >= raw*2/3 {
+		t.Fatalf("block size %d, raw size %d", size, raw)
+	}
+}
+
+func Test_Block_Empty(t *testing.T) {
+	var builder BlockBuilder
+	block := New(builder.Finish())
+	if block == nil {
+		t.Fatal("bad empty block")
+	}
+	it := block.NewIterator()
+	it.SeekToFirst()
+	if it.Valid() {
+		t.Fatal("empty block is valid")
+	}
+	it.Seek(internal.LookupKey([]byte("a"), math.MaxUint64))
+	if it.Valid() {
+		t.Fatal("empty block is valid")
+	}
+}
+
+func Test_Block_Corrupt(t *testing.T) {
+	var builder BlockBuilder
+	for _, item := range testItems(20) {
+		builder.Add(item)
+	}
+	p := builder.Finish()
+	if New(p) == nil {
+		t.Fatal("bad block")
+	}
+	// restart个数超出block大小
+	bad := append([]byte(nil), p...)
+	bad[len(bad)-1] = 0xff
+	if New(bad) != nil {
+		t.Fatal("bad restart count accepted")
+	}
+	// 截断的entry在遍历时才发现
+	block := New(append(append([]byte(nil), p[:10]...), p[len(p)-8:]...))
+	if block == nil {
+		t.Fatal("bad block")
+	}
+	it := block.NewIterator()
+	it.SeekToFirst()
+	if it.Valid() || it.Error() != internal.ErrBlockCorruption {
+		t.Fatalf("truncated entry: valid = %v, err = %v", it.Valid(), it.Error())
+	}
+	if New([]byte{1, 2}) != nil {
+		t.Fatal("short block accepted")
+	}
+}
+
+// 遍历和查找时不分配内存
+func Test_Block_IteratorAllocs(t *testing.T) {
+	items := testItems(100)
+	var builder BlockBuilder
+	for _, item := range items {
+		builder.Add(item)
+	}
+	it := New(builder.Finish()).NewIterator()
+	it.SeekToLast() // keyBuf分配到足够大
+	allocs := testing.AllocsPerRun(100, func() {
+		it.Seek(items[50])
+		for ; it.Valid(); it.Next() {
+		}
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("allocs = %v", allocs)
+	}
+}
+
+func BenchmarkBlock_Seek(b *testing.B) {
+	items := testItems(200)
+	var builder BlockBuilder
+	for _, item := range items {
+		builder.Add(item)
+	}
+	block := New(builder.Finish())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := block.NewIterator()
+		it.Seek(items[i%len(items)])
+	}
+}
@@ -1,67 +1,205 @@
 package block
 
 import (
+	"encoding/binary"
+
 	"github.com/merlin82/leveldb/internal"
 )
 
+// InternalKey()返回的key和value直接引用block的数据和迭代器内部的buffer，
+// 迭代器移动以后就会失效，需要保留时调用方自己复制
 type Iterator struct {
-	block *Block
-	index int
+	block        *Block
+	current      int // 当前entry在data中的偏移量，>= restartOffset表示无效
+	next         int // 下一个entry的偏移量
+	restartIndex int // 当前entry所在的restart区间
+	keyBuf       []byte
+	scratch      [64]byte // 短key直接放在这里，不需要再分配keyBuf
+	key          internal.InternalKey
+	raw          bool // key按原样返回，不解析seq和type
+	err          error
 }
 
 // Returns true iff the iterator is positioned at a valid node.
 func (it *Iterator) Valid() bool {
-	return it.index >= 0 && it.index < len(it.block.items)
+	return it.current < it.block.restartOffset
 }
 
 func (it *Iterator) InternalKey() *internal.InternalKey {
-	return &it.block.items[it.index]
+	return &it.key
 }
 
 // Advances to the next position.
 // REQUIRES: Valid()
 func (it *Iterator) Next() {
-	it.index++
+	it.parseNextKey()
 }
 
 // Advances to the previous position.
 // REQUIRES: Valid()
 func (it *Iterator) Prev() {
-	it.index--
+	// Scan backwards to a restart point before current
+	original := it.current
+	for it.block.restartPoint(it.restartIndex) >= original {
+		if it.restartIndex == 0 {
+			// No more entries
+			it.invalidate()
+			return
+		}
+		it.restartIndex--
+	}
+
+	it.seekToRestartPoint(it.restartIndex)
+	// Loop until end of current entry hits the start of original entry
+	for it.parseNextKey() && it.next < original {
+	}
 }
 
 // Advance to the first entry with a key >= target
-func (it *Iterator) Seek(target interface{}) {
-	// 二分法查询
+func (it *Iterator) Seek(target *internal.InternalKey) {
+	// Binary search in restart array to find the last restart point
+	// with a key < target
 	left := 0
-	right := len(it.block.items) - 1
+	right := it.block.numRestarts - 1
+	var midKey internal.InternalKey
 	for left < right {
-		mid := (left + right) / 2
-		if internal.UserKeyComparator(it.block.items[mid].UserKey, target) < 0 {
-			left = mid + 1
+		mid := (left + right + 1) / 2
+		if !it.decodeRestartKey(mid, &midKey) {
+			return
+		}
+		if internal.InternalKeyComparator(&midKey, target) < 0 {
+			// Key at "mid" is smaller than "target".  Therefore all
+			// blocks before "mid" are uninteresting.
+			left = mid
 		} else {
-			right = mid
+			// Key at "mid" is >= "target".  Therefore all blocks at or
+			// after "mid" are uninteresting.
+			right = mid - 1
 		}
 	}
-	if left == len(it.block.items)-1 {
-		if internal.UserKeyComparator(it.block.items[left].UserKey, target) < 0 {
-			// not found
-			left++
+
+	// Linear search (within restart block) for first key >= target
+	it.seekToRestartPoint(left)
+	for it.parseNextKey() {
+		if internal.InternalKeyComparator(&it.key, target) >= 0 {
+			return
 		}
 	}
-	it.index = left
 }
 
 // Position at the first entry in list.
 // Final state of iterator is Valid() iff list is not empty.
 func (it *Iterator) SeekToFirst() {
-	it.index = 0
+	it.seekToRestartPoint(0)
+	it.parseNextKey()
 }
 
 // Position at the last entry in list.
 // Final state of iterator is Valid() iff list is not empty.
 func (it *Iterator) SeekToLast() {
-	if len(it.block.items) > 0 {
-		it.index = len(it.block.items) - 1
+	it.seekToRestartPoint(it.block.numRestarts - 1)
+	for it.parseNextKey() && it.next < it.block.restartOffset {
+		// Keep skipping
+	}
+}
+
+// entry格式不对时返回internal.ErrBlockCorruption
+func (it *Iterator) Error() error {
+	return it.err
+}
+
+func (it *Iterator) invalidate() {
+	it.current = it.block.restartOffset
+	it.restartIndex = it.block.numRestarts
+}
+
+func (it *Iterator) corruption() bool {
+	it.invalidate()
+	it.err = internal.ErrBlockCorruption
+	return false
+}
+
+// 下一次parseNextKey从restart point开始
+func (it *Iterator) seekToRestartPoint(index int) {
+	it.keyBuf = it.keyBuf[:0]
+	if index < 0 || index >= it.block.numRestarts {
+		// 没有restart point的block是空的
+		it.invalidate()
+		it.next = it.block.restartOffset
+		return
+	}
+	it.restartIndex = index
+	it.next = it.block.restartPoint(index)
+	if it.next > it.block.restartOffset {
+		it.corruption()
+	}
+}
+
+// 解码next处的entry，key的前缀复用keyBuf中上一个key的数据，value直接引用block的数据
+func (it *Iterator) parseNextKey() bool {
+	if it.err != nil {
+		return false
+	}
+	it.current = it.next
+	data := it.block.data[:it.block.restartOffset]
+	if it.current >= len(data) {
+		// No more entries to return.  Mark as invalid.
+		it.invalidate()
+		return false
+	}
+
+	// Decode next entry
+	shared, nonShared, valueLength, n := decodeEntry(data[it.current:])
+	p := data[it.current+n:]
+	if n == 0 || shared > len(it.keyBuf) || nonShared+valueLength > len(p) {
+		return it.corruption()
+	}
+	it.keyBuf = append(it.keyBuf[:shared], p[:nonShared]...)
+	if !it.decodeKey(it.keyBuf, &it.key) {
+		return it.corruption()
+	}
+	it.key.UserValue = p[nonShared : nonShared+valueLength]
+	it.next = it.current + n + nonShared + valueLength
+	for it.restartIndex+1 < it.block.numRestarts && it.block.restartPoint(it.restartIndex+1) < it.current {
+		it.restartIndex++
+	}
+	return true
+}
+
+// restart point处的key完整保存，直接引用block的数据解码
+func (it *Iterator) decodeRestartKey(index int, key *internal.InternalKey) bool {
+	offset := it.block.restartPoint(index)
+	data := it.block.data[:it.block.restartOffset]
+	if offset >= len(data) {
+		return it.corruption()
+	}
+	shared, nonShared, _, n := decodeEntry(data[offset:])
+	if n == 0 || shared != 0 || nonShared > len(data)-offset-n {
+		return it.corruption()
+	}
+	if !it.decodeKey(data[offset+n:offset+n+nonShared], key) {
+		return it.corruption()
+	}
+	return true
+}
+
+// 和internal.DecodeInternalKey相同，但是不复制user key
+func (it *Iterator) decodeKey(p []byte, key *internal.InternalKey) bool {
+	if it.raw {
+		// seq为0，和LookupKey比较时同一个user key的entry排在后面，Seek可以找到
+		key.Seq, key.Type, key.UserKey = 0, internal.TypeValue, p
+		return true
+	}
+	if len(p) < 8 {
+		return false
+	}
+	n := len(p) - 8
+	trailer := binary.LittleEndian.Uint64(p[n:])
+	key.Seq = trailer >> 8
+	key.Type = internal.ValueType(trailer & 0xff)
+	if key.Type != internal.TypeDeletion && key.Type != internal.TypeValue {
+		return false
 	}
+	key.UserKey = p[:n]
+	return true
 }
@@ -3,84 +3,218 @@ package sstable
 import (
 	"encoding/binary"
 	"io"
+	"math"
 
 	"github.com/merlin82/leveldb/internal"
 )
 
 const (
+	// c++版本和FormatFixed32格式的footer使用的magic
 	kTableMagicNumber uint64 = 0xdb4775248b80fb57
+	// footer中带版本号时使用的magic，和kTableMagicNumber区分新旧格式
+	kVersionedTableMagicNumber uint64 = 0x9e3d6a0f1c54b72d
+
+	// 每个block后面跟着1字节的压缩类型和4字节的masked crc32c(block数据+类型)
+	// BlockHandle.Size是压缩后的大小，不包含trailer
+	blockTrailerSize = 5
+
+	// varint64编码的offset和size最多各10字节
+	maxEncodedHandleLength = 10 + 10
 )
 
+// sstable的文件格式版本，读取时根据footer自动识别，写入时由Options.FormatVersion指定
+const (
+	// BlockHandle为固定宽度的uint32 offset和size，文件不能超过4GB，footer中没有版本号
+	FormatFixed32 = 1
+	// BlockHandle为varint64编码的offset和size，footer中记录版本号
+	FormatVarint64 = 2
+	// 和c++版本的文件完全一致：BlockHandle为varint64编码，footer 48字节没有版本号，
+	// metaindex block中的key是原始的字符串，不是internal key
+	FormatLevelDB = 3
+
+	CurrentFormatVersion = FormatVarint64
+)
+
+// 压缩后至少减少12.5%才使用压缩后的数据，否则直接保存原始数据
+func compressedEnough(rawSize, compressedSize int) bool {
+	return compressedSize < rawSize-rawSize/8
+}
+
 type BlockHandle struct {
-	Offset uint32
-	Size   uint32
+	Offset uint64
+	Size   uint64
+}
+
+//结构体数据转成字符串，按version的格式编码
+func (blockHandle *BlockHandle) EncodeToBytes(version int) []byte {
+	if version == FormatFixed32 {
+		p := make([]byte, 8)
+		binary.LittleEndian.PutUint32(p, uint32(blockHandle.Offset))
+		binary.LittleEndian.PutUint32(p[4:], uint32(blockHandle.Size))
+		return p
+	}
+	p := make([]byte, maxEncodedHandleLength)
+	n := binary.PutUvarint(p, blockHandle.Offset)
+	n += binary.PutUvarint(p[n:], blockHandle.Size)
+	return p[:n]
 }
 
-//结构体数据转成字符串
-func (blockHandle *BlockHandle) EncodeToBytes() []byte {
-	p := make([]byte, 8)
-	binary.LittleEndian.PutUint32(p, blockHandle.Offset)
-	binary.LittleEndian.PutUint32(p[4:], blockHandle.Size)
-	return p
+//字符传转结构体数据，p的格式不对时返回false
+func (blockHandle *BlockHandle) DecodeFromBytes(p []byte, version int) bool {
+	return blockHandle.decodeFrom(p, version) == len(p)
 }
 
-//字符传转结构体数据
-func (blockHandle *BlockHandle) DecodeFromBytes(p []byte) {
-	if len(p) == 8 {
-		blockHandle.Offset = binary.LittleEndian.Uint32(p)
-		blockHandle.Size = binary.LittleEndian.Uint32(p[4:])
+// 返回读取的字节数，格式不对时返回0
+func (blockHandle *BlockHandle) decodeFrom(p []byte, version int) int {
+	if version == FormatFixed32 {
+		if len(p) < 8 {
+			return 0
+		}
+		blockHandle.Offset = uint64(binary.LittleEndian.Uint32(p))
+		blockHandle.Size = uint64(binary.LittleEndian.Uint32(p[4:]))
+		return 8
 	}
+	offset, n := binary.Uvarint(p)
+	if n <= 0 {
+		return 0
+	}
+	size, m := binary.Uvarint(p[n:])
+	if m <= 0 {
+		return 0
+	}
+	blockHandle.Offset, blockHandle.Size = offset, size
+	return n + m
 }
 
 type IndexBlockHandle struct {
 	*internal.InternalKey
 }
 
-func (index *IndexBlockHandle) SetBlockHandle(blockHandle BlockHandle) {
-	index.UserValue = blockHandle.EncodeToBytes()
+func (index *IndexBlockHandle) SetBlockHandle(blockHandle BlockHandle, version int) {
+	index.UserValue = blockHandle.EncodeToBytes(version)
 }
 
-func (index *IndexBlockHandle) GetBlockHandle() (blockHandle BlockHandle) {
-	blockHandle.DecodeFromBytes(index.UserValue)
+func (index *IndexBlockHandle) GetBlockHandle(version int) (blockHandle BlockHandle, ok bool) {
+	ok = blockHandle.DecodeFromBytes(index.UserValue, version)
 	return
 }
 
 type Footer struct {
+	Version         int // 0时按CurrentFormatVersion写入
 	MetaIndexHandle BlockHandle
 	IndexHandle     BlockHandle
 }
 
-// ...DATA....
+// FormatFixed32:
 // ...DATA....
 // | mete_offset  | mete_size  |  8B
 // | index_offset | index_size |  8B
 // |        magic_number       |  8B
+//
+// FormatVarint64:
+// ...DATA....
+// | metaindex_handle | index_handle | padding |  40B
+// |          format_version                  |  4B
+// |           magic_number                   |  8B
+//
+// FormatLevelDB:
+// ...DATA....
+// | metaindex_handle | index_handle | padding |  40B
+// |           magic_number                   |  8B
+const (
+	fixed32FooterSize   = 8 + 8 + 8
+	versionedFooterSize = 2*maxEncodedHandleLength + 4 + 8
+	levelDBFooterSize   = 2*maxEncodedHandleLength + 8
+
+	// 读取footer时从文件末尾读取的字节数
+	maxFooterSize = versionedFooterSize
+)
+
+func (footer *Footer) version() int {
+	if footer.Version == 0 {
+		return CurrentFormatVersion
+	}
+	return footer.Version
+}
+
 func (footer *Footer) Size() int {
-	// add magic size
-	return binary.Size(footer) + 8
+	switch footer.version() {
+	case FormatFixed32:
+		return fixed32FooterSize
+	case FormatLevelDB:
+		return levelDBFooterSize
+	}
+	return versionedFooterSize
 }
 
 func (footer *Footer) EncodeTo(w io.Writer) error {
-	err := binary.Write(w, binary.LittleEndian, footer)
-	if err != nil {
-		return err
+	version := footer.version()
+	p := make([]byte, footer.Size())
+	if version == FormatFixed32 {
+		copy(p, footer.MetaIndexHandle.EncodeToBytes(version))
+		copy(p[8:], footer.IndexHandle.EncodeToBytes(version))
+		binary.LittleEndian.PutUint64(p[16:], kTableMagicNumber)
+	} else if version == FormatLevelDB {
+		n := copy(p, footer.MetaIndexHandle.EncodeToBytes(version))
+		copy(p[n:], footer.IndexHandle.EncodeToBytes(version))
+		binary.LittleEndian.PutUint64(p[2*maxEncodedHandleLength:], kTableMagicNumber)
+	} else {
+		n := copy(p, footer.MetaIndexHandle.EncodeToBytes(version))
+		copy(p[n:], footer.IndexHandle.EncodeToBytes(version))
+		binary.LittleEndian.PutUint32(p[2*maxEncodedHandleLength:], uint32(version))
+		binary.LittleEndian.PutUint64(p[2*maxEncodedHandleLength+4:], kVersionedTableMagicNumber)
 	}
-	err = binary.Write(w, binary.LittleEndian, kTableMagicNumber)
+	_, err := w.Write(p)
 	return err
 }
 
-func (footer *Footer) DecodeFrom(r io.Reader) error {
-	err := binary.Read(r, binary.LittleEndian, footer)
-	if err != nil {
-		return err
+// p是文件最后的maxFooterSize字节，文件比较小时可以更短，根据magic识别footer的格式
+func (footer *Footer) DecodeFrom(p []byte, fileSize uint64) error {
+	if len(p) < fixed32FooterSize {
+		return internal.ErrTableFileTooShort
 	}
-	var magic uint64
-	err = binary.Read(r, binary.LittleEndian, &magic)
-	if err != nil {
-		return err
+	switch binary.LittleEndian.Uint64(p[len(p)-8:]) {
+	case kTableMagicNumber:
+		// FormatFixed32和c++版本的magic相同，index block总是紧挨着footer，
+		// 按FormatFixed32解析出的index block刚好在footer前面结束就是FormatFixed32
+		var fixed Footer
+		fixed.Version = FormatFixed32
+		fixed.MetaIndexHandle.decodeFrom(p[len(p)-fixed32FooterSize:], FormatFixed32)
+		fixed.IndexHandle.decodeFrom(p[len(p)-fixed32FooterSize+8:], FormatFixed32)
+		if fixed.IndexHandle.Offset+fixed.IndexHandle.Size+blockTrailerSize+fixed32FooterSize == fileSize {
+			*footer = fixed
+			return nil
+		}
+		if len(p) < levelDBFooterSize {
+			return internal.ErrTableFileTooShort
+		}
+		p = p[len(p)-levelDBFooterSize:]
+		footer.Version = FormatLevelDB
+		n := footer.MetaIndexHandle.decodeFrom(p[:2*maxEncodedHandleLength], footer.Version)
+		if n == 0 || footer.IndexHandle.decodeFrom(p[n:2*maxEncodedHandleLength], footer.Version) == 0 {
+			return internal.ErrTableFooterCorruption
+		}
+		return nil
+	case kVersionedTableMagicNumber:
+		if len(p) < versionedFooterSize {
+			return internal.ErrTableFileTooShort
+		}
+		p = p[len(p)-versionedFooterSize:]
+		version := binary.LittleEndian.Uint32(p[2*maxEncodedHandleLength:])
+		if version != FormatVarint64 {
+			return internal.ErrTableFormatVersion
+		}
+		footer.Version = int(version)
+		n := footer.MetaIndexHandle.decodeFrom(p[:2*maxEncodedHandleLength], footer.Version)
+		if n == 0 || footer.IndexHandle.decodeFrom(p[n:2*maxEncodedHandleLength], footer.Version) == 0 {
+			return internal.ErrTableFooterCorruption
+		}
+		return nil
 	}
-	if magic != kTableMagicNumber {
-		return internal.ErrTableFileMagic
-	}
-	return nil
+	return internal.ErrTableFileMagic
+}
+
+// version格式能否记录到end为止的offset
+func handleFits(end uint64, version int) bool {
+	return version != FormatFixed32 || end <= math.MaxUint32
 }
@@ -0,0 +1,128 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/merlin82/leveldb/filter"
+	"github.com/merlin82/leveldb/internal"
+)
+
+// testdata/leveldb下的文件由c++版本的LevelDB生成，见testdata/leveldb/README.md
+func compatFile(t *testing.T, name string) string {
+	fileName := filepath.Join("..", "testdata", "leveldb", name)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		t.Skipf("%s has not been generated, see testdata/leveldb/README.md", fileName)
+	}
+	return fileName
+}
+
+// 和testdata/leveldb/gen_fixtures.cc中的TableEntries一致
+func compatTableEntries() []*internal.InternalKey {
+	var entries []*internal.InternalKey
+	for i := 0; i < 600; i++ {
+		userKey := []byte(fmt.Sprintf("%05d.%s", i*2, "abcdefghij"[:i%11]))
+		value := bytes.Repeat([]byte(fmt.Sprintf("v%d-", i)), 1+i%5)
+		entries = append(entries, internal.NewInternalKey(uint64(1000+i), internal.TypeValue, userKey, value))
+		if i%7 == 0 {
+			entries = append(entries, internal.NewInternalKey(uint64(i+1), internal.TypeDeletion, userKey, nil))
+		}
+	}
+	return entries
+}
+
+// Go写出的sstable和c++版本逐字节相同。snappy的压缩结果和c++的实现不同，只比较不压缩的
+func Test_Compat_WriteTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"table.ldb", Options{}},
+		{"table_bloom.ldb", Options{FilterPolicy: filter.NewBloomFilterPolicy(10)}},
+	}
+	for _, test := range tests {
+		want, err := ioutil.ReadFile(compatFile(t, test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.opts.FormatVersion = FormatLevelDB
+		fileName := filepath.Join(dir, test.name)
+		builder, err := NewTableBuilder(fileName, &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range compatTableEntries() {
+			builder.Add(entry)
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			i := 0
+			for i < len(got) && i < len(want) && got[i] == want[i] {
+				i++
+			}
+			t.Fatalf("%s: %d bytes, want %d bytes, first difference at offset %d", test.name, len(got), len(want), i)
+		}
+	}
+}
+
+func Test_Compat_ReadTable(t *testing.T) {
+	policy := filter.NewBloomFilterPolicy(10)
+	entries := compatTableEntries()
+	for _, name := range []string{"table.ldb", "table_bloom.ldb", "table_snappy.ldb"} {
+		table, err := Open(compatFile(t, name), &Options{FilterPolicy: policy})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if table.footer.Version != FormatLevelDB {
+			t.Fatalf("%s: format version %d", name, table.footer.Version)
+		}
+		if (table.filter != nil) != (name == "table_bloom.ldb") {
+			t.Fatalf("%s: filter = %v", name, table.filter)
+		}
+
+		it := table.NewIterator()
+		it.SetReadOptions(ReadOptions{VerifyChecksums: true})
+		i := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			key, entry := it.InternalKey(), entries[i]
+			if !bytes.Equal(key.UserKey, entry.UserKey) || key.Seq != entry.Seq || key.Type != entry.Type ||
+				!bytes.Equal(key.UserValue, entry.UserValue) {
+				t.Fatalf("%s: entry %d = %s@%d, want %s@%d", name, i, key.UserKey, key.Seq, entry.UserKey, entry.Seq)
+			}
+			i++
+		}
+		if i != len(entries) || it.Error() != nil {
+			t.Fatalf("%s: %d entries, err = %v", name, i, it.Error())
+		}
+		it.Close()
+
+		for _, entry := range entries {
+			if entry.Type != internal.TypeValue {
+				continue
+			}
+			value, err := table.Get(internal.LookupKey(entry.UserKey, math.MaxUint64), &ReadOptions{VerifyChecksums: true})
+			if err != nil || !bytes.Equal(value, entry.UserValue) {
+				t.Fatalf("%s: Get(%s) = %s, %v", name, entry.UserKey, value, err)
+			}
+		}
+		table.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package sstable
+
+import (
+	"encoding/binary"
+
+	"github.com/merlin82/leveldb/filter"
+)
+
+// 每2KB的data block偏移量生成一个filter
+const (
+	filterBaseLg = 11
+	filterBase   = 1 << filterBaseLg
+)
+
+// filter block格式：
+//    [filter 0]
+//    [filter 1]
+//    ...
+//    [filter N-1]
+//    [offset of filter 0]      : 4 bytes
+//    ...
+//    [offset of filter N-1]    : 4 bytes
+//    [offset of beginning of offset array] : 4 bytes
+//    lg(base)                  : 1 byte
+type FilterBlockBuilder struct {
+	policy        filter.FilterPolicy
+	keys          [][]byte // 当前filter还没生成的key
+	result        []byte   // 已经生成的filter
+	filterOffsets []uint32
+}
+
+func NewFilterBlockBuilder(policy filter.FilterPolicy) *FilterBlockBuilder {
+	return &FilterBlockBuilder{policy: policy}
+}
+
+// 新的data block从blockOffset开始，之前的key生成filter
+func (builder *FilterBlockBuilder) StartBlock(blockOffset uint64) {
+	filterIndex := blockOffset / filterBase
+	for filterIndex > uint64(len(builder.filterOffsets)) {
+		builder.generateFilter()
+	}
+}
+
+func (builder *FilterBlockBuilder) AddKey(key []byte) {
+	builder.keys = append(builder.keys, append([]byte(nil), key...))
+}
+
+func (builder *FilterBlockBuilder) Finish() []byte {
+	if len(builder.keys) > 0 {
+		builder.generateFilter()
+	}
+	// Append array of per-filter offsets
+	arrayOffset := uint32(len(builder.result))
+	p := make([]byte, 4)
+	for _, offset := range builder.filterOffsets {
+		binary.LittleEndian.PutUint32(p, offset)
+		builder.result = append(builder.result, p...)
+	}
+	binary.LittleEndian.PutUint32(p, arrayOffset)
+	builder.result = append(builder.result, p...)
+	builder.result = append(builder.result, filterBaseLg) // Save encoding parameter in result
+	return builder.result
+}
+
+func (builder *FilterBlockBuilder) generateFilter() {
+	builder.filterOffsets = append(builder.filterOffsets, uint32(len(builder.result)))
+	if len(builder.keys) == 0 {
+		// Fast path if there are no keys for this filter
+		return
+	}
+	builder.result = append(builder.result, builder.policy.CreateFilter(builder.keys)...)
+	builder.keys = builder.keys[:0]
+}
+
+type FilterBlockReader struct {
+	policy filter.FilterPolicy
+	data   []byte // filter数据，不含offset数组
+	offset []byte // offset数组的开始位置
+	num    uint32 // filter的个数
+	baseLg uint8
+}
+
+// contents格式不对时返回的reader对所有key都返回true
+func NewFilterBlockReader(policy filter.FilterPolicy, contents []byte) *FilterBlockReader {
+	reader := &FilterBlockReader{policy: policy}
+	n := uint32(len(contents))
+	if n < 5 { // 1 byte for base_lg and 4 for start of offset array
+		return reader
+	}
+	reader.baseLg = contents[n-1]
+	lastWord := binary.LittleEndian.Uint32(contents[n-5:])
+	if lastWord > n-5 {
+		return reader
+	}
+	reader.data = contents[:lastWord]
+	reader.offset = contents[lastWord : n-1]
+	reader.num = (n - 5 - lastWord) / 4
+	return reader
+}
+
+// 从blockOffset开始的data block中是否可能有key
+func (reader *FilterBlockReader) KeyMayMatch(blockOffset uint64, key []byte) bool {
+	index := blockOffset >> reader.baseLg
+	if index < uint64(reader.num) {
+		start := binary.LittleEndian.Uint32(reader.offset[index*4:])
+		limit := binary.LittleEndian.Uint32(reader.offset[index*4+4:])
+		if start <= limit && limit <= uint32(len(reader.data)) {
+			return reader.policy.KeyMayMatch(key, reader.data[start:limit])
+		} else if start == limit {
+			// Empty filters do not match any keys
+			return false
+		}
+	}
+	return true // Errors are treated as potential matches
+}
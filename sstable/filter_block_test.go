@@ -0,0 +1,117 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// 把所有key的hash直接拼起来作为filter，方便检查每个filter里有哪些key
+type testHashFilter struct{}
+
+func (testHashFilter) Name() string {
+	return "TestHashFilter"
+}
+
+func (testHashFilter) CreateFilter(keys [][]byte) []byte {
+	var dst []byte
+	p := make([]byte, 4)
+	for _, key := range keys {
+		binary.LittleEndian.PutUint32(p, testHash(key))
+		dst = append(dst, p...)
+	}
+	return dst
+}
+
+func (testHashFilter) KeyMayMatch(key, filter []byte) bool {
+	h := testHash(key)
+	for i := 0; i+4 <= len(filter); i += 4 {
+		if binary.LittleEndian.Uint32(filter[i:]) == h {
+			return true
+		}
+	}
+	return false
+}
+
+func testHash(key []byte) uint32 {
+	h := uint32(1)
+	for _, c := range key {
+		h = h*31 + uint32(c)
+	}
+	return h
+}
+
+func Test_FilterBlock_Empty(t *testing.T) {
+	builder := NewFilterBlockBuilder(testHashFilter{})
+	block := builder.Finish()
+	if !bytes.Equal(block, []byte{0, 0, 0, 0, filterBaseLg}) {
+		t.Fatalf("unexpected empty filter block: %v", block)
+	}
+	reader := NewFilterBlockReader(testHashFilter{}, block)
+	if !reader.KeyMayMatch(0, []byte("foo")) || !reader.KeyMayMatch(100000, []byte("foo")) {
+		t.Fatal("empty filter block must match")
+	}
+}
+
+func Test_FilterBlock_SingleChunk(t *testing.T) {
+	builder := NewFilterBlockBuilder(testHashFilter{})
+	builder.StartBlock(100)
+	builder.AddKey([]byte("foo"))
+	builder.AddKey([]byte("bar"))
+	builder.AddKey([]byte("box"))
+	builder.StartBlock(200)
+	builder.AddKey([]byte("box"))
+	builder.StartBlock(300)
+	builder.AddKey([]byte("hello"))
+	reader := NewFilterBlockReader(testHashFilter{}, builder.Finish())
+	for _, key := range []string{"foo", "bar", "box", "hello"} {
+		if !reader.KeyMayMatch(100, []byte(key)) {
+			t.Fatalf("%s missing", key)
+		}
+	}
+	if reader.KeyMayMatch(100, []byte("missing")) || reader.KeyMayMatch(100, []byte("other")) {
+		t.Fatal("unexpected match")
+	}
+}
+
+func Test_FilterBlock_MultiChunk(t *testing.T) {
+	builder := NewFilterBlockBuilder(testHashFilter{})
+
+	// First filter
+	builder.StartBlock(0)
+	builder.AddKey([]byte("foo"))
+	builder.StartBlock(2000)
+	builder.AddKey([]byte("bar"))
+
+	// Second filter
+	builder.StartBlock(3100)
+	builder.AddKey([]byte("box"))
+
+	// Third filter is empty
+
+	// Last filter
+	builder.StartBlock(9000)
+	builder.AddKey([]byte("box"))
+	builder.AddKey([]byte("hello"))
+
+	reader := NewFilterBlockReader(testHashFilter{}, builder.Finish())
+	tests := []struct {
+		offset uint64
+		key    string
+		match  bool
+	}{
+		// Check first filter
+		{0, "foo", true}, {2000, "bar", true}, {0, "box", false}, {0, "hello", false},
+		// Check second filter
+		{3100, "box", true}, {3100, "foo", false}, {3100, "bar", false}, {3100, "hello", false},
+		// Check third filter (empty)
+		{4100, "foo", false}, {4100, "bar", false}, {4100, "box", false}, {4100, "hello", false},
+		// Check last filter
+		{9000, "box", true}, {9000, "hello", true}, {9000, "foo", false}, {9000, "bar", false},
+	}
+	for _, test := range tests {
+		if got := reader.KeyMayMatch(test.offset, []byte(test.key)); got != test.match {
+			t.Errorf("KeyMayMatch(%d, %s) = %v, want %v", test.offset, test.key, got, test.match)
+		}
+	}
+}
@@ -10,6 +10,50 @@ type Iterator struct {
 	dataBlockHandle BlockHandle
 	dataIter        *block.Iterator
 	indexIter       *block.Iterator
+	upperBound      []byte
+	opts            ReadOptions
+	err             error // 读取data block失败时记录第一个错误，跳过这个block继续遍历
+	closed          bool
+}
+
+// 调用方不需要>=upperBound的key，正向遍历时不再读取完全超出范围的data block
+// 返回的key仍然可能>=upperBound，由调用方过滤
+func (it *Iterator) SetUpperBound(upperBound []byte) {
+	it.upperBound = upperBound
+}
+
+// 默认不校验checksum，读到的data block也不放入block cache，避免遍历大量数据时把热点block挤出缓存
+func (it *Iterator) SetReadOptions(opts ReadOptions) {
+	it.opts = opts
+}
+
+// 遍历过程中遇到的错误，比如data block损坏
+func (it *Iterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	if err := it.indexIter.Error(); err != nil {
+		return it.table.corruption(it.table.footer.IndexHandle, err.Error())
+	}
+	if it.dataIter != nil && it.dataIter.Error() != nil {
+		return it.table.corruption(it.dataBlockHandle, it.dataIter.Error().Error())
+	}
+	return nil
+}
+
+// 释放对table的引用，之后不能再使用迭代器
+func (it *Iterator) Close() {
+	if !it.closed {
+		it.closed = true
+		it.table.Close()
+	}
+}
+
+// 替换dataIter之前保留它的错误
+func (it *Iterator) saveError() {
+	if it.err == nil && it.dataIter != nil && it.dataIter.Error() != nil {
+		it.err = it.table.corruption(it.dataBlockHandle, it.dataIter.Error().Error())
+	}
 }
 
 // Returns true iff the iterator is positioned at a valid node.
@@ -48,7 +92,7 @@ func (it *Iterator) Prev() {
 }
 
 // Advance to the first entry with a key >= target
-func (it *Iterator) Seek(target []byte) {
+func (it *Iterator) Seek(target *internal.InternalKey) {
 	// Index Block的block_data字段中，每一条记录的key都满足：
 	// 大于等于Data Block的所有key，并且小于后面所有Data Block的key
 	// 因为Seek是查找key>=target的第一条记录，所以当index_iter_找到时，
@@ -89,17 +133,35 @@ func (it *Iterator) SeekToLast() {
 
 func (it *Iterator) initDataBlock() {
 	if !it.indexIter.Valid() {
+		it.saveError()
 		it.dataIter = nil
 	} else {
 		var index IndexBlockHandle
 		index.InternalKey = it.indexIter.InternalKey()
-		tmpBlockHandle := index.GetBlockHandle()
+		tmpBlockHandle, ok := index.GetBlockHandle(it.table.footer.Version)
+		if !ok {
+			it.saveError()
+			if it.err == nil {
+				it.err = it.table.corruption(it.table.footer.IndexHandle, "bad block handle")
+			}
+			it.dataIter = nil
+			return
+		}
 
 		if it.dataIter != nil && it.dataBlockHandle == tmpBlockHandle {
 			// data_iter_ is already constructed with this iterator, so
 			// no need to change anything
 		} else {
-			it.dataIter = it.table.readBlock(tmpBlockHandle).NewIterator()
+			it.saveError()
+			dataBlock, err := it.table.blockReader(tmpBlockHandle, &it.opts)
+			if err != nil {
+				if it.err == nil {
+					it.err = err
+				}
+				it.dataIter = nil
+				return
+			}
+			it.dataIter = dataBlock.NewIterator()
 			it.dataBlockHandle = tmpBlockHandle
 		}
 	}
@@ -108,6 +170,13 @@ func (it *Iterator) initDataBlock() {
 func (it *Iterator) skipEmptyDataBlocksForward() {
 	for it.dataIter == nil || !it.dataIter.Valid() {
 		if !it.indexIter.Valid() {
+			it.saveError()
+			it.dataIter = nil
+			return
+		}
+		// index key是block中最大的key，后面block的key都比它大
+		if it.upperBound != nil && internal.UserKeyComparator(it.indexIter.InternalKey().UserKey, it.upperBound) >= 0 {
+			it.saveError()
 			it.dataIter = nil
 			return
 		}
@@ -122,6 +191,7 @@ func (it *Iterator) skipEmptyDataBlocksForward() {
 func (it *Iterator) skipEmptyDataBlocksBackward() {
 	for it.dataIter == nil || !it.dataIter.Valid() {
 		if !it.indexIter.Valid() {
+			it.saveError()
 			it.dataIter = nil
 			return
 		}
@@ -0,0 +1,35 @@
+package sstable
+
+import (
+	"github.com/merlin82/leveldb/cache"
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/filter"
+)
+
+// 读写sstable时的配置，nil等同于零值
+type Options struct {
+	// 不为nil时每个sstable写入filter block，Get时先查filter再读data block
+	FilterPolicy filter.FilterPolicy
+	// 不为nil时读取的data block放入缓存，key为(table id, block offset)
+	BlockCache cache.Cache
+	// 写入block时使用的压缩算法，nil时不压缩。读取时根据block的类型选择算法，和这里的配置无关
+	Compressor compress.Compressor
+	// data block中每隔多少个key做一次restart，0时使用默认值16。
+	// 越大block越小，但是查找时需要顺序比较的key越多
+	BlockRestartInterval int
+	// 写入的文件格式版本，0时使用CurrentFormatVersion，FormatLevelDB写出的文件c++版本可以直接读取。
+	// 读取时根据footer识别，和这里的配置无关
+	FormatVersion int
+}
+
+var defaultOptions Options
+
+// 读取sstable时的选项，nil等同于零值
+type ReadOptions struct {
+	// 读取data block时校验checksum，index等元数据block总是校验
+	VerifyChecksums bool
+	// 读到的data block放入block cache
+	FillCache bool
+}
+
+var defaultReadOptions ReadOptions
@@ -1,81 +1,248 @@
 package sstable
 
 import (
+	"encoding/binary"
 	"io"
+	"math"
 	"os"
+	"sync/atomic"
 
+	"github.com/merlin82/leveldb/cache"
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/filter"
 	"github.com/merlin82/leveldb/internal"
 	"github.com/merlin82/leveldb/sstable/block"
 )
 
 type SsTable struct {
-	index  *block.Block
-	footer Footer
-	file   *os.File
+	name    string
+	index   *block.Block
+	footer  Footer
+	file    *os.File
+	size    uint64             // 文件大小，用来检查BlockHandle是否越界
+	filter  *FilterBlockReader // 没有filter block或者FilterPolicy不一致时为nil
+	cache   cache.Cache
+	cacheID uint64 // 区分不同sstable在block cache中的key
+	refs    int32  // Open返回时为1，每个迭代器再持有一个，全部释放后关闭文件
 }
 
-func Open(fileName string) (*SsTable, error) {
+func Open(fileName string, opts *Options) (*SsTable, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
 	var table SsTable
 	var err error
+	table.name = fileName
 	// sstbale文件描述符
 	table.file, err = os.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
 	// 文件大小
-	stat, _ := table.file.Stat()
+	stat, err := table.file.Stat()
+	if err != nil {
+		table.file.Close()
+		return nil, err
+	}
+	table.size = uint64(stat.Size())
 	// Read the footer block
-	footerSize := int64(table.footer.Size())
-	if stat.Size() < footerSize {
-		return nil, internal.ErrTableFileTooShort
+	// 新旧格式的footer长度不同，先读最后maxFooterSize字节，再根据magic识别
+	footerSize := uint64(maxFooterSize)
+	if table.size < footerSize {
+		footerSize = table.size
 	}
-	// 最后24B字节数据
-	_, err = table.file.Seek(-footerSize, io.SeekEnd)
-	if err != nil {
+	p := make([]byte, footerSize)
+	if _, err = table.file.ReadAt(p, int64(table.size-footerSize)); err != nil {
+		table.file.Close()
 		return nil, err
 	}
-	// 24B数据转为footer结构体数据
-	err = table.footer.DecodeFrom(table.file)
+	err = table.footer.DecodeFrom(p, table.size)
 	if err != nil {
+		table.file.Close()
 		return nil, err
 	}
 	// footer里面有meta和index的offset和size数据
-	// 现在只实现了index没有meta，否则也需要读取
-	// 读取第一个block
-	table.index = table.readBlock(table.footer.IndexHandle)
+	// 元数据block总是校验checksum
+	table.index, err = table.readBlock(table.footer.IndexHandle, true)
+	if err != nil {
+		table.file.Close()
+		return nil, err
+	}
+	if opts.FilterPolicy != nil {
+		table.readMeta(opts.FilterPolicy)
+	}
+	if opts.BlockCache != nil {
+		table.cache = opts.BlockCache
+		table.cacheID = opts.BlockCache.NewId()
+	}
+	table.refs = 1
 	return &table, nil
 }
 
+// 增加一个引用，每次调用都需要对应一次Close
+func (table *SsTable) Ref() *SsTable {
+	atomic.AddInt32(&table.refs, 1)
+	return table
+}
+
+// 释放Open返回的或者Ref增加的引用，全部释放以后关闭文件
+// 还没有Close的迭代器可以继续使用
+func (table *SsTable) Close() error {
+	if atomic.AddInt32(&table.refs, -1) == 0 {
+		return table.file.Close()
+	}
+	return nil
+}
+
+// 读取metaindex中和policy同名的filter block，读取失败时不使用filter
+func (table *SsTable) readMeta(policy filter.FilterPolicy) {
+	if table.footer.MetaIndexHandle.Size == 0 {
+		return
+	}
+	meta, err := table.readBlock(table.footer.MetaIndexHandle, true)
+	if err != nil {
+		return
+	}
+	key := []byte(filterMetaKeyPrefix + policy.Name())
+	it := meta.NewIterator()
+	if table.footer.Version == FormatLevelDB {
+		it = meta.NewRawIterator()
+	}
+	it.Seek(internal.LookupKey(key, math.MaxUint64))
+	if !it.Valid() || internal.UserKeyComparator(it.InternalKey().UserKey, key) != 0 {
+		return
+	}
+	var filterHandle BlockHandle
+	if !filterHandle.DecodeFromBytes(it.InternalKey().UserValue, table.footer.Version) {
+		return
+	}
+	p, err := table.readBlockContents(filterHandle, true)
+	if err != nil {
+		return
+	}
+	table.filter = NewFilterBlockReader(policy, p)
+}
+
+// 默认不校验data block的checksum，读到的data block不放入block cache
+// 迭代器引用住table，用完以后需要调用Close
 func (table *SsTable) NewIterator() *Iterator {
 	var it Iterator
-	it.table = table
+	it.table = table.Ref()
 	it.indexIter = table.index.NewIterator()
 	return &it
 }
 
-func (table *SsTable) Get(key []byte) ([]byte, error) {
-	it := table.NewIterator()
-	it.Seek(key)
+// opts为nil时使用零值
+func (table *SsTable) Get(lookupKey *internal.InternalKey, opts *ReadOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &defaultReadOptions
+	}
+	// index中第一个>=lookupKey的data block就是key所在的block，
+	// index key是block中最大的key，所以这个block中一定有>=lookupKey的key
+	indexIter := table.index.NewIterator()
+	indexIter.Seek(lookupKey)
+	if !indexIter.Valid() {
+		if err := indexIter.Error(); err != nil {
+			return nil, table.corruption(table.footer.IndexHandle, err.Error())
+		}
+		return nil, internal.ErrNotFound
+	}
+	index := IndexBlockHandle{InternalKey: indexIter.InternalKey()}
+	blockHandle, ok := index.GetBlockHandle(table.footer.Version)
+	if !ok {
+		return nil, table.corruption(table.footer.IndexHandle, "bad block handle")
+	}
+	if table.filter != nil && !table.filter.KeyMayMatch(blockHandle.Offset, lookupKey.UserKey) {
+		return nil, internal.ErrNotFound
+	}
+
+	dataBlock, err := table.blockReader(blockHandle, opts)
+	if err != nil {
+		return nil, err
+	}
+	it := dataBlock.NewIterator()
+	it.Seek(lookupKey)
 	if it.Valid() {
 		internalKey := it.InternalKey()
-		if internal.UserKeyComparator(key, internalKey.UserKey) == 0 {
+		if internal.UserKeyComparator(lookupKey.UserKey, internalKey.UserKey) == 0 {
 			// 判断valueType
 			if internalKey.Type == internal.TypeValue {
-				return internalKey.UserValue, nil
+				// value引用的是block的数据，block可能在block cache中被其他读共享
+				return append([]byte(nil), internalKey.UserValue...), nil
 			} else {
 				return nil, internal.ErrDeletion
 			}
 		}
 	}
+	if err := it.Error(); err != nil {
+		return nil, table.corruption(blockHandle, err.Error())
+	}
 	return nil, internal.ErrNotFound
 }
 
-func (table *SsTable) readBlock(blockHandle BlockHandle) *block.Block {
-	p := make([]byte, blockHandle.Size)
+// 先查block cache，没有时从文件读取，opts.FillCache为true时把读到的block放入缓存
+func (table *SsTable) blockReader(blockHandle BlockHandle, opts *ReadOptions) (*block.Block, error) {
+	if table.cache == nil {
+		return table.readBlock(blockHandle, opts.VerifyChecksums)
+	}
+	key := make([]byte, 16)
+	binary.LittleEndian.PutUint64(key, table.cacheID)
+	binary.LittleEndian.PutUint64(key[8:], blockHandle.Offset)
+	if value, ok := table.cache.Lookup(key); ok {
+		return value.(*block.Block), nil
+	}
+	b, err := table.readBlock(blockHandle, opts.VerifyChecksums)
+	if err == nil && opts.FillCache {
+		table.cache.Insert(key, b, b.Size())
+	}
+	return b, err
+}
+
+func (table *SsTable) readBlock(blockHandle BlockHandle, verifyChecksums bool) (*block.Block, error) {
+	p, err := table.readBlockContents(blockHandle, verifyChecksums)
+	if err != nil {
+		return nil, err
+	}
+	b := block.New(p)
+	if b == nil {
+		return nil, table.corruption(blockHandle, "bad block contents")
+	}
+	return b, nil
+}
+
+// 读取block数据并检查trailer，返回解压后的数据，不包含trailer
+func (table *SsTable) readBlockContents(blockHandle BlockHandle, verifyChecksums bool) ([]byte, error) {
+	// 先检查是否超出文件范围，避免损坏的BlockHandle导致分配过大的内存
+	if blockHandle.Offset > table.size || blockHandle.Size > table.size-blockHandle.Offset ||
+		table.size-blockHandle.Offset-blockHandle.Size < blockTrailerSize {
+		return nil, table.corruption(blockHandle, "truncated block read")
+	}
+	p := make([]byte, blockHandle.Size+blockTrailerSize)
 	n, err := table.file.ReadAt(p, int64(blockHandle.Offset))
-	if err != nil || uint32(n) != blockHandle.Size {
-		return nil
+	if n != len(p) {
+		if err == nil || err == io.EOF {
+			return nil, table.corruption(blockHandle, "truncated block read")
+		}
+		return nil, err
 	}
+	content, trailer := p[:blockHandle.Size], p[blockHandle.Size:]
+	if verifyChecksums {
+		crc := internal.UnmaskCrc(binary.LittleEndian.Uint32(trailer[1:]))
+		if internal.Crc32c(content, trailer[:1]) != crc {
+			return nil, table.corruption(blockHandle, "block checksum mismatch")
+		}
+	}
+	compressor := compress.Lookup(trailer[0])
+	if compressor == nil {
+		return nil, table.corruption(blockHandle, "bad block type")
+	}
+	content, err = compressor.Decompress(content)
+	if err != nil {
+		return nil, table.corruption(blockHandle, "corrupted compressed block contents")
+	}
+	return content, nil
+}
 
-	return block.New(p)
+func (table *SsTable) corruption(blockHandle BlockHandle, reason string) error {
+	return &internal.CorruptionError{File: table.name, Offset: blockHandle.Offset, Reason: reason}
 }
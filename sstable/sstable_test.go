@@ -1,15 +1,33 @@
 package sstable
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 
 	"testing"
 
+	"github.com/merlin82/leveldb/cache"
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/filter"
 	"github.com/merlin82/leveldb/internal"
 )
 
 func Test_SsTable(t *testing.T) {
-	builder := NewTableBuilder("/Users/liuhuan210/go/src/github.com/merlin82/leveldb/test/000123.ldb")
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000123.ldb")
+	builder, err := NewTableBuilder(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	item := internal.NewInternalKey(1, internal.TypeValue, []byte("123"), []byte("1234"))
 	builder.Add(item)
 	item = internal.NewInternalKey(2, internal.TypeValue, []byte("124"), []byte("1245"))
@@ -18,14 +36,14 @@ func Test_SsTable(t *testing.T) {
 	builder.Add(item)
 	builder.Finish()
 
-	table, err := Open("/Users/liuhuan210/go/src/github.com/merlin82/leveldb/test/000123.ldb")
+	table, err := Open(fileName, nil)
 	fmt.Println(err)
 	if err == nil {
 		fmt.Println(table.index)
 		fmt.Println(table.footer)
 	}
 	it := table.NewIterator()
-	it.Seek([]byte("1244"))
+	it.Seek(internal.LookupKey([]byte("1244"), math.MaxUint64))
 	if it.Valid() {
 		if string(it.InternalKey().UserKey) != "125" {
 			t.Fail()
@@ -34,3 +52,577 @@ func Test_SsTable(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_SsTable_Close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000123.ldb")
+	builder, err := NewTableBuilder(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, []byte(fmt.Sprintf("%06d", i)), []byte("value")))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 迭代器引用住table，table.Close以后还可以继续遍历
+	it := table.NewIterator()
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 1000 || it.Error() != nil {
+		t.Fatal(n, it.Error())
+	}
+	it.Close()
+	it.Close()
+	if _, err := table.file.Stat(); err == nil {
+		t.Fatal("file is still open after the last reference is released")
+	}
+}
+
+func Test_SsTable_Filter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000123.ldb")
+	opts := &Options{FilterPolicy: filter.NewBloomFilterPolicy(10)}
+	builder, err := NewTableBuilder(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i*2))
+		builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+	}
+	builder.Finish()
+
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.filter == nil {
+		t.Fatal("filter block not loaded")
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i*2))
+		value, err := table.Get(internal.LookupKey(key, math.MaxUint64), nil)
+		if err != nil || string(value) != string(key) {
+			t.Fatalf("Get(%s) = %s, %v", key, value, err)
+		}
+		key = []byte(fmt.Sprintf("key%06d", i*2+1))
+		if _, err := table.Get(internal.LookupKey(key, math.MaxUint64), nil); err != internal.ErrNotFound {
+			t.Fatalf("Get(%s) = %v", key, err)
+		}
+	}
+
+	// 没有配置FilterPolicy时不读filter block
+	table, err = Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.filter != nil {
+		t.Fatal("filter loaded without policy")
+	}
+	if value, err := table.Get(internal.LookupKey([]byte("key000010"), math.MaxUint64), nil); err != nil || string(value) != "key000010" {
+		t.Fatalf("Get without filter = %s, %v", value, err)
+	}
+}
+
+func Test_SsTable_BlockCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000123.ldb")
+	builder, err := NewTableBuilder(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+	}
+	builder.Finish()
+
+	opts := &Options{BlockCache: cache.NewLRUCache(1 << 20)}
+	table, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 默认的迭代器不放入缓存
+	it := table.NewIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+	}
+	if opts.BlockCache.TotalCharge() != 0 {
+		t.Fatalf("iterator filled cache: %d", opts.BlockCache.TotalCharge())
+	}
+
+	lookupKey := internal.LookupKey([]byte("key000500"), math.MaxUint64)
+	fillCache := &ReadOptions{FillCache: true}
+	if _, err := table.Get(lookupKey, fillCache); err != nil {
+		t.Fatal(err)
+	}
+	misses := opts.BlockCache.Misses()
+	if opts.BlockCache.TotalCharge() == 0 {
+		t.Fatal("Get did not fill cache")
+	}
+	if value, err := table.Get(lookupKey, fillCache); err != nil || string(value) != "key000500" {
+		t.Fatalf("Get = %s, %v", value, err)
+	}
+	if opts.BlockCache.Misses() != misses || opts.BlockCache.Hits() == 0 {
+		t.Fatalf("hits = %d, misses = %d", opts.BlockCache.Hits(), opts.BlockCache.Misses())
+	}
+
+	// 同一个文件再次打开使用新的id，不会读到之前的缓存
+	table2, err := Open(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table2.cacheID == table.cacheID {
+		t.Fatal("duplicate cache id")
+	}
+}
+
+// 写一个有多个data block的sstable，返回文件名
+func buildTestTable(t *testing.T, dir string, n int) string {
+	fileName := filepath.Join(dir, "000123.ldb")
+	builder, err := NewTableBuilder(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func corruptFile(t *testing.T, fileName string, offset int64) {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 1)
+	if _, err := f.ReadAt(p, offset); err != nil {
+		t.Fatal(err)
+	}
+	p[0] ^= 0x80
+	if _, err := f.WriteAt(p, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SsTable_VerifyChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := buildTestTable(t, dir, 1000)
+	// 破坏第一个data block中key000000的value
+	corruptFile(t, fileName, 40)
+
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := &ReadOptions{VerifyChecksums: true}
+	_, err = table.Get(internal.LookupKey([]byte("key000000"), math.MaxUint64), verify)
+	if !internal.IsCorruption(err) {
+		t.Fatalf("Get = %v, want corruption", err)
+	}
+	// 其他block不受影响
+	if value, err := table.Get(internal.LookupKey([]byte("key000999"), math.MaxUint64), verify); err != nil || string(value) != "key000999" {
+		t.Fatalf("Get = %s, %v", value, err)
+	}
+
+	// 迭代器跳过损坏的block，并通过Error()返回错误
+	it := table.NewIterator()
+	it.SetReadOptions(*verify)
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n == 0 || n >= 1000 || !internal.IsCorruption(it.Error()) {
+		t.Fatalf("n = %d, err = %v", n, it.Error())
+	}
+
+	// 不校验时能读到数据
+	it = table.NewIterator()
+	n = 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 1000 || it.Error() != nil {
+		t.Fatalf("n = %d, err = %v", n, it.Error())
+	}
+}
+
+func Test_SsTable_CorruptIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := buildTestTable(t, dir, 1000)
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexOffset := int64(table.footer.IndexHandle.Offset)
+	table.file.Close()
+
+	// index block总是校验checksum
+	corruptFile(t, fileName, indexOffset+1)
+	if _, err := Open(fileName, nil); !internal.IsCorruption(err) {
+		t.Fatalf("Open = %v, want corruption", err)
+	}
+}
+
+func Test_SsTable_TruncatedBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := buildTestTable(t, dir, 1000)
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := BlockHandle{Offset: table.footer.IndexHandle.Offset, Size: table.footer.IndexHandle.Size + 100}
+	if _, err := table.readBlockContents(handle, false); !internal.IsCorruption(err) {
+		t.Fatalf("readBlockContents = %v, want corruption", err)
+	}
+}
+
+func Test_SsTable_Compression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(301))
+	random := make([]byte, 1000)
+	build := func(name string, compressor compress.Compressor, compressible bool) (string, int64) {
+		fileName := filepath.Join(dir, name)
+		builder, err := NewTableBuilder(fileName, &Options{Compressor: compressor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			value := []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","score":%d}`, i, i%7, i%100))
+			if !compressible {
+				r.Read(random)
+				value = random
+			}
+			builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, value))
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileName, stat.Size()
+	}
+
+	_, rawSize := build("raw.ldb", nil, true)
+	for _, c := range []compress.Compressor{compress.Snappy, compress.Flate} {
+		fileName, size := build(c.Name()+".ldb", c, true)
+		if size >= rawSize/2 {
+			t.Fatalf("%s: size %d, raw size %d", c.Name(), size, rawSize)
+		}
+		// 读取时根据block类型解压，不需要配置Compressor
+		table, err := Open(fileName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := table.NewIterator()
+		it.SetReadOptions(ReadOptions{VerifyChecksums: true})
+		n := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if expected := fmt.Sprintf("key%06d", n); string(it.Key()) != expected {
+				t.Fatalf("%s: key = %s, want %s", c.Name(), it.Key(), expected)
+			}
+			n++
+		}
+		if n != 1000 || it.Error() != nil {
+			t.Fatalf("%s: n = %d, err = %v", c.Name(), n, it.Error())
+		}
+	}
+
+	// 随机数据压缩不到12.5%，直接保存原始数据
+	fileName, _ := build("random.ldb", compress.Snappy, false)
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexIter := table.index.NewIterator()
+	indexIter.SeekToFirst()
+	var index IndexBlockHandle
+	index.InternalKey = indexIter.InternalKey()
+	handle, _ := index.GetBlockHandle(table.footer.Version)
+	p := make([]byte, 1)
+	if _, err := table.file.ReadAt(p, int64(handle.Offset+handle.Size)); err != nil {
+		t.Fatal(err)
+	}
+	if p[0] != compress.None.Type() {
+		t.Fatalf("block type = %d, want raw", p[0])
+	}
+}
+
+func Test_SsTable_UnknownCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := buildTestTable(t, dir, 1000)
+	table, err := Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexIter := table.index.NewIterator()
+	indexIter.SeekToFirst()
+	var index IndexBlockHandle
+	index.InternalKey = indexIter.InternalKey()
+	handle, _ := index.GetBlockHandle(table.footer.Version)
+	table.file.Close()
+
+	// 不校验checksum时也能发现未知的block类型
+	corruptFile(t, fileName, int64(handle.Offset+handle.Size))
+	table, err = Open(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Get(internal.LookupKey([]byte("key000000"), math.MaxUint64), nil); !internal.IsCorruption(err) {
+		t.Fatalf("Get = %v, want corruption", err)
+	}
+}
+
+// Get返回的value是副本，修改它不影响block cache中的数据
+func Test_SsTable_GetCopiesValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := buildTestTable(t, dir, 1000)
+	table, err := Open(fileName, &Options{BlockCache: cache.NewLRUCache(1 << 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookupKey := internal.LookupKey([]byte("key000500"), math.MaxUint64)
+	opts := &ReadOptions{FillCache: true}
+	value, err := table.Get(lookupKey, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'x'
+	if value, err := table.Get(lookupKey, opts); err != nil || string(value) != "key000500" {
+		t.Fatalf("Get = %s, %v", value, err)
+	}
+}
+
+func Test_SsTable_FormatFixed32(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 旧格式的文件仍然可以读取，包括index和metaindex中的BlockHandle
+	opts := &Options{FilterPolicy: filter.NewBloomFilterPolicy(10)}
+	for _, version := range []int{FormatFixed32, FormatVarint64, FormatLevelDB} {
+		opts.FormatVersion = version
+		fileName := filepath.Join(dir, fmt.Sprintf("%06d.ldb", version))
+		builder, err := NewTableBuilder(fileName, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+		}
+		if err := builder.Finish(); err != nil {
+			t.Fatal(err)
+		}
+
+		table, err := Open(fileName, &Options{FilterPolicy: opts.FilterPolicy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.footer.Version != version || table.size != builder.FileSize() {
+			t.Fatalf("footer = %+v, file size %d, table size %d", table.footer, table.size, builder.FileSize())
+		}
+		if table.filter == nil {
+			t.Fatalf("version %d: filter block not loaded", version)
+		}
+		value, err := table.Get(internal.LookupKey([]byte("key000500"), math.MaxUint64), nil)
+		if err != nil || string(value) != "key000500" {
+			t.Fatalf("version %d: Get = %s, %v", version, value, err)
+		}
+		n := 0
+		it := table.NewIterator()
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			n++
+		}
+		if n != 1000 || it.Error() != nil {
+			t.Fatalf("version %d: n = %d, err = %v", version, n, it.Error())
+		}
+	}
+}
+
+func Test_TableBuilder_LargeOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 不真的写4GB数据，直接修改builder记录的offset
+	build := func(version int) (*TableBuilder, error) {
+		builder, err := NewTableBuilder(filepath.Join(dir, "000001.ldb"), &Options{FormatVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.offset = math.MaxUint32 - 100
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+		}
+		return builder, builder.Finish()
+	}
+	if _, err := build(FormatFixed32); err != internal.ErrTableFileTooLarge {
+		t.Fatalf("FormatFixed32: err = %v, want %v", err, internal.ErrTableFileTooLarge)
+	}
+	builder, err := build(FormatVarint64)
+	if err != nil || builder.FileSize() <= math.MaxUint32 {
+		t.Fatalf("FormatVarint64: err = %v, size = %d", err, builder.FileSize())
+	}
+	if _, err := build(99); err != internal.ErrTableFormatVersion {
+		t.Fatalf("unknown version: err = %v", err)
+	}
+}
+
+func Test_BlockHandle(t *testing.T) {
+	handles := []BlockHandle{
+		{0, 0},
+		{4096, 123},
+		{math.MaxUint32, math.MaxUint32},
+		{1 << 40, 1 << 33},
+		{math.MaxUint64, math.MaxUint64},
+	}
+	for _, handle := range handles {
+		p := handle.EncodeToBytes(FormatVarint64)
+		if len(p) > maxEncodedHandleLength {
+			t.Fatalf("%+v: encoded length %d", handle, len(p))
+		}
+		var decoded BlockHandle
+		if !decoded.DecodeFromBytes(p, FormatVarint64) || decoded != handle {
+			t.Fatalf("%+v: decoded %+v", handle, decoded)
+		}
+		if decoded.DecodeFromBytes(p[:len(p)-1], FormatVarint64) {
+			t.Fatalf("%+v: truncated handle decoded", handle)
+		}
+	}
+	handle := BlockHandle{Offset: 4096, Size: 123}
+	var decoded BlockHandle
+	if !decoded.DecodeFromBytes(handle.EncodeToBytes(FormatFixed32), FormatFixed32) || decoded != handle {
+		t.Fatalf("FormatFixed32: decoded %+v", decoded)
+	}
+}
+
+func Test_Footer(t *testing.T) {
+	for _, version := range []int{FormatFixed32, FormatVarint64, FormatLevelDB} {
+		footer := Footer{
+			Version:         version,
+			MetaIndexHandle: BlockHandle{Offset: 1000, Size: 20},
+			IndexHandle:     BlockHandle{Offset: 1025, Size: 300},
+		}
+		var buf bytes.Buffer
+		if err := footer.EncodeTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != footer.Size() {
+			t.Fatalf("version %d: encoded %d bytes, want %d", version, buf.Len(), footer.Size())
+		}
+		// 读取时前面可能还有其他数据，index block紧挨着footer
+		p := append(make([]byte, 100), buf.Bytes()...)
+		fileSize := footer.IndexHandle.Offset + footer.IndexHandle.Size + blockTrailerSize + uint64(footer.Size())
+		var decoded Footer
+		if err := decoded.DecodeFrom(p[len(p)-maxFooterSize:], fileSize); err != nil || decoded != footer {
+			t.Fatalf("version %d: decoded %+v, %v", version, decoded, err)
+		}
+	}
+
+	var footer, decoded Footer
+	var buf bytes.Buffer
+	footer.EncodeTo(&buf)
+	p := buf.Bytes()
+	p[2*maxEncodedHandleLength] = 99
+	if err := decoded.DecodeFrom(p, uint64(len(p))); err != internal.ErrTableFormatVersion {
+		t.Fatalf("err = %v, want %v", err, internal.ErrTableFormatVersion)
+	}
+	p[len(p)-1] ^= 0xff
+	if err := decoded.DecodeFrom(p, uint64(len(p))); err != internal.ErrTableFileMagic {
+		t.Fatalf("err = %v, want %v", err, internal.ErrTableFileMagic)
+	}
+}
+
+func BenchmarkSsTable_Get(b *testing.B) {
+	dir, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000123.ldb")
+	builder, err := NewTableBuilder(fileName, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var keys []*internal.InternalKey
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		builder.Add(internal.NewInternalKey(uint64(i), internal.TypeValue, key, key))
+		keys = append(keys, internal.LookupKey(key, math.MaxUint64))
+	}
+	builder.Finish()
+	opts := &ReadOptions{FillCache: true}
+	table, err := Open(fileName, &Options{BlockCache: cache.NewLRUCache(8 << 20)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := table.Get(keys[i%len(keys)], opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
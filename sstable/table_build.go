@@ -1,39 +1,71 @@
 package sstable
 
 import (
+	"encoding/binary"
 	"os"
 
+	"github.com/merlin82/leveldb/compress"
+	"github.com/merlin82/leveldb/filter"
 	"github.com/merlin82/leveldb/internal"
 	"github.com/merlin82/leveldb/sstable/block"
 )
 
 const (
 	MAX_BLOCK_SIZE = 4 * 1024 // 4K
+
+	filterMetaKeyPrefix = "filter."
 )
 
 type TableBuilder struct {
-	file               *os.File
-	offset             uint32
-	numEntries         int32
-	dataBlockBuilder   block.BlockBuilder
-	indexBlockBuilder  block.BlockBuilder
-	pendingIndexEntry  bool
-	pendingIndexHandle IndexBlockHandle
-	status             error
+	file              *os.File
+	offset            uint64
+	version           int // 写入的文件格式版本
+	numEntries        int32
+	dataBlockBuilder  block.BlockBuilder
+	indexBlockBuilder block.BlockBuilder
+	pendingIndexEntry bool
+	pendingHandle     BlockHandle          // 上一个data block的位置，下一个key加入时才写入index block
+	lastKey           internal.InternalKey // 最后加入的key的副本，不含value
+	filterBlock       *FilterBlockBuilder  // 没有配置FilterPolicy时为nil
+	filterPolicy      filter.FilterPolicy
+	compressor        compress.Compressor // 不压缩时为nil
+	status            error
 }
 
-func NewTableBuilder(fileName string) *TableBuilder {
+// 创建文件失败时返回错误
+func NewTableBuilder(fileName string, opts *Options) (*TableBuilder, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
 	var builder TableBuilder
 	var err error
 	builder.file, err = os.Create(fileName)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	builder.pendingIndexEntry = false
-	return &builder
+	builder.version = opts.FormatVersion
+	if builder.version == 0 {
+		builder.version = CurrentFormatVersion
+	}
+	if builder.version != FormatFixed32 && builder.version != FormatVarint64 && builder.version != FormatLevelDB {
+		builder.status = internal.ErrTableFormatVersion
+	}
+	builder.dataBlockBuilder.RestartInterval = opts.BlockRestartInterval
+	// index block中的key很少，每个都做restart，查找时只需要二分
+	builder.indexBlockBuilder.RestartInterval = 1
+	if opts.Compressor != nil && opts.Compressor.Type() != compress.None.Type() {
+		builder.compressor = opts.Compressor
+	}
+	if opts.FilterPolicy != nil {
+		builder.filterPolicy = opts.FilterPolicy
+		builder.filterBlock = NewFilterBlockBuilder(opts.FilterPolicy)
+		builder.filterBlock.StartBlock(0)
+	}
+	return &builder, nil
 }
 
-func (builder *TableBuilder) FileSize() uint32 {
+func (builder *TableBuilder) FileSize() uint64 {
 	return builder.offset
 }
 
@@ -42,17 +74,23 @@ func (builder *TableBuilder) Add(internalKey *internal.InternalKey) {
 		return
 	}
 	if builder.pendingIndexEntry {
-		builder.indexBlockBuilder.Add(builder.pendingIndexHandle.InternalKey)
-		builder.pendingIndexEntry = false
+		// index key只需要在上一个block的最后一个key和这个key之间，尽量取短的
+		builder.addIndexEntry(internal.FindShortestSeparator(&builder.lastKey, internalKey))
+	}
+	// filter里只放user key，同一个key的多个版本只占一个位置
+	if builder.filterBlock != nil {
+		builder.filterBlock.AddKey(internalKey.UserKey)
 	}
-	// todo : filter block
 
-	builder.pendingIndexHandle.InternalKey = internalKey
+	// 调用方的internalKey可能在Add返回后被修改，flush时需要用到最后一个key，所以复制一份
+	builder.lastKey.Seq = internalKey.Seq
+	builder.lastKey.Type = internalKey.Type
+	builder.lastKey.UserKey = append(builder.lastKey.UserKey[:0], internalKey.UserKey...)
 
 	builder.numEntries++
 	builder.dataBlockBuilder.Add(internalKey)
 	// 4KB 刷盘一次
-	if builder.dataBlockBuilder.CurrentSizeEstimate() > MAX_BLOCK_SIZE {
+	if builder.dataBlockBuilder.CurrentSizeEstimate() >= MAX_BLOCK_SIZE {
 		builder.flush()
 	}
 }
@@ -60,40 +98,102 @@ func (builder *TableBuilder) flush() {
 	if builder.dataBlockBuilder.Empty() {
 		return
 	}
-	orgKey := builder.pendingIndexHandle.InternalKey
-	builder.pendingIndexHandle.InternalKey = internal.NewInternalKey(orgKey.Seq, orgKey.Type, orgKey.UserKey, nil)
-	builder.pendingIndexHandle.SetBlockHandle(builder.writeblock(&builder.dataBlockBuilder))
+	builder.pendingHandle = builder.writeblock(&builder.dataBlockBuilder)
 	builder.pendingIndexEntry = true
+	if builder.filterBlock != nil {
+		builder.filterBlock.StartBlock(builder.offset)
+	}
 }
 
 func (builder *TableBuilder) Finish() error {
 	// write data block
 	builder.flush()
-	// todo : filter block
+	footer := Footer{Version: builder.version}
+
+	// write filter block
+	var metaIndexBlockBuilder block.BlockBuilder
+	if builder.filterBlock != nil {
+		filterBlockHandle := builder.writeRawBlock(builder.filterBlock.Finish(), compress.None.Type())
+		// metaindex中记录filter block的位置，key为"filter."+policy名字
+		key := []byte(filterMetaKeyPrefix + builder.filterPolicy.Name())
+		if builder.version == FormatLevelDB {
+			// c++版本metaindex中的key不带seq和type
+			metaIndexBlockBuilder.AddKeyValue(key, filterBlockHandle.EncodeToBytes(builder.version))
+		} else {
+			metaIndexBlockBuilder.Add(internal.NewInternalKey(0, internal.TypeValue, key, filterBlockHandle.EncodeToBytes(builder.version)))
+		}
+	}
+
+	// write metaindex block
+	footer.MetaIndexHandle = builder.writeblock(&metaIndexBlockBuilder)
 
 	// write index block
 	if builder.pendingIndexEntry {
-		builder.indexBlockBuilder.Add(builder.pendingIndexHandle.InternalKey)
-		builder.pendingIndexEntry = false
+		builder.addIndexEntry(internal.FindShortSuccessor(&builder.lastKey))
 	}
-	var footer Footer
 	footer.IndexHandle = builder.writeblock(&builder.indexBlockBuilder)
 
 	// write footer block
-	footer.EncodeTo(builder.file)
+	if builder.status == nil {
+		builder.status = footer.EncodeTo(builder.file)
+	}
+	builder.offset += uint64(footer.Size())
+	if err := builder.file.Close(); builder.status == nil {
+		builder.status = err
+	}
+	return builder.status
+}
+
+// 不再写入，关闭文件，由调用方删除
+func (builder *TableBuilder) Abandon() {
 	builder.file.Close()
-	return nil
+}
+
+func (builder *TableBuilder) addIndexEntry(key *internal.InternalKey) {
+	index := IndexBlockHandle{InternalKey: key}
+	index.SetBlockHandle(builder.pendingHandle, builder.version)
+	builder.indexBlockBuilder.Add(index.InternalKey)
+	builder.pendingIndexEntry = false
 }
 
 func (builder *TableBuilder) writeblock(blockBuilder *block.BlockBuilder) BlockHandle {
-	content := blockBuilder.Finish()
-	// todo : compress, crc
+	raw := blockBuilder.Finish()
+	content, blockType := raw, compress.None.Type()
+	// 超过MaxBlockSize的block读取时不能解压，直接不压缩
+	if builder.compressor != nil && len(raw) <= compress.MaxBlockSize {
+		compressed := builder.compressor.Compress(raw)
+		if compressedEnough(len(raw), len(compressed)) {
+			content, blockType = compressed, builder.compressor.Type()
+		}
+	}
+	blockHandle := builder.writeRawBlock(content, blockType)
+	blockBuilder.Reset()
+	return blockHandle
+}
+
+// content已经按blockType压缩过
+func (builder *TableBuilder) writeRawBlock(content []byte, blockType byte) BlockHandle {
 	var blockHandle BlockHandle
 	blockHandle.Offset = builder.offset
-	blockHandle.Size = uint32(len(content))
-	builder.offset += uint32(len(content))
-	_, builder.status = builder.file.Write(content)
+	blockHandle.Size = uint64(len(content))
+	if builder.status != nil {
+		return blockHandle
+	}
+	// 旧格式的BlockHandle是uint32，超过4GB时报错，不能让offset回绕
+	if !handleFits(builder.offset+uint64(len(content))+blockTrailerSize, builder.version) {
+		builder.status = internal.ErrTableFileTooLarge
+		return blockHandle
+	}
+
+	// trailer: type + masked crc
+	var trailer [blockTrailerSize]byte
+	trailer[0] = blockType
+	binary.LittleEndian.PutUint32(trailer[1:], internal.MaskCrc(internal.Crc32c(content, trailer[:1])))
+
+	builder.offset += uint64(len(content)) + blockTrailerSize
+	if _, builder.status = builder.file.Write(content); builder.status == nil {
+		_, builder.status = builder.file.Write(trailer[:])
+	}
 	builder.file.Sync()
-	blockBuilder.Reset()
 	return blockHandle
 }
@@ -12,13 +12,16 @@ func makeKeyValue() (string, string) {
 }
 
 func main() {
-	db := leveldb.Open("./test/a")
+	db, err := leveldb.Open("./test/a", nil)
+	if err != nil {
+		panic(err)
+	}
 	for i := 0; i < 100; i++ {
 		key, val := makeKeyValue()
-		_ = db.Put([]byte(key), []byte(val))
+		_ = db.Put([]byte(key), []byte(val), nil)
 	}
 	db.PrintMem()
 	db.PrintVersion()
-	_, _ = db.Get([]byte("cccc"))
-	_ = db.Delete([]byte("cccc"))
+	_, _ = db.Get([]byte("cccc"), nil)
+	_ = db.Delete([]byte("cccc"), nil)
 }
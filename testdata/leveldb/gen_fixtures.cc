@@ -0,0 +1,204 @@
+// 用c++版本的LevelDB生成兼容性测试用的文件，编译和运行的命令见同目录的README.md
+//   table.ldb         不压缩的sstable
+//   table_snappy.ldb  snappy压缩的sstable
+//   table_bloom.ldb   带bloom filter的sstable
+//   records.log       log::Writer写的若干条记录，覆盖block结尾补0和空FIRST的情况
+//   manifest          log::Writer写的两条VersionEdit
+//   db/               DB::Open写的完整db目录，打开两次，第二次的写入留在wal中
+// 这里的数据和各个包的compat_test.go一一对应，修改时需要同步
+#include <cstdint>
+#include <cstdio>
+#include <cstdlib>
+#include <string>
+#include <vector>
+
+#include "db/dbformat.h"
+#include "db/log_writer.h"
+#include "db/version_edit.h"
+#include "leveldb/comparator.h"
+#include "leveldb/db.h"
+#include "leveldb/env.h"
+#include "leveldb/filter_policy.h"
+#include "leveldb/options.h"
+#include "leveldb/table_builder.h"
+#include "leveldb/write_batch.h"
+
+namespace {
+
+using leveldb::InternalKey;
+using leveldb::Status;
+
+void Check(const Status& s) {
+  if (!s.ok()) {
+    std::fprintf(stderr, "%s\n", s.ToString().c_str());
+    std::exit(1);
+  }
+}
+
+leveldb::WritableFile* NewFile(const std::string& name) {
+  leveldb::WritableFile* file;
+  Check(leveldb::Env::Default()->NewWritableFile(name, &file));
+  return file;
+}
+
+void CloseFile(leveldb::WritableFile* file) {
+  Check(file->Sync());
+  Check(file->Close());
+  delete file;
+}
+
+std::string Repeat(const std::string& s, int n) {
+  std::string result;
+  for (int i = 0; i < n; i++) {
+    result += s;
+  }
+  return result;
+}
+
+// 0, 1, ..., 250, 0, 1, ...
+std::string Pattern(int n) {
+  std::string result;
+  for (int i = 0; i < n; i++) {
+    result.push_back(static_cast<char>(i % 251));
+  }
+  return result;
+}
+
+// sstable/compat_test.go中的compatTableEntries
+std::vector<std::pair<std::string, std::string>> TableEntries() {
+  std::vector<std::pair<std::string, std::string>> entries;
+  char buf[32];
+  for (int i = 0; i < 600; i++) {
+    std::snprintf(buf, sizeof(buf), "%05d.", i * 2);
+    std::string user_key = buf + std::string("abcdefghij", i % 11);
+    std::snprintf(buf, sizeof(buf), "v%d-", i);
+    std::string value = Repeat(buf, 1 + i % 5);
+    entries.emplace_back(
+        InternalKey(user_key, 1000 + i, leveldb::kTypeValue).Encode().ToString(),
+        value);
+    if (i % 7 == 0) {
+      entries.emplace_back(
+          InternalKey(user_key, i + 1, leveldb::kTypeDeletion).Encode().ToString(),
+          "");
+    }
+  }
+  return entries;
+}
+
+// 和db写sstable时一样，key是InternalKey，filter中只放user key
+void WriteTable(const std::string& name, leveldb::CompressionType compression,
+                const leveldb::FilterPolicy* filter_policy) {
+  leveldb::InternalKeyComparator icmp(leveldb::BytewiseComparator());
+  leveldb::InternalFilterPolicy ipolicy(filter_policy);
+  leveldb::Options options;
+  options.comparator = &icmp;
+  options.compression = compression;
+  options.filter_policy = filter_policy != nullptr ? &ipolicy : nullptr;
+
+  leveldb::WritableFile* file = NewFile(name);
+  leveldb::TableBuilder builder(options, file);
+  for (const auto& entry : TableEntries()) {
+    builder.Add(entry.first, entry.second);
+  }
+  Check(builder.Finish());
+  CloseFile(file);
+}
+
+// log/compat_test.go中的compatRecords
+void WriteLog(const std::string& name) {
+  std::vector<std::string> records = {
+      "foo", "", Pattern(100000),
+      // 写完以后block只剩3字节，下一条记录之前补0
+      std::string(31017, 'x'), "bar",
+      // 写完以后block刚好剩一个header，下一条记录先写一个空的FIRST
+      std::string(32744, 'y'), "baz",
+  };
+  leveldb::WritableFile* file = NewFile(name);
+  leveldb::log::Writer writer(file);
+  for (const std::string& record : records) {
+    Check(writer.AddRecord(record));
+  }
+  CloseFile(file);
+}
+
+// version/compat_test.go中的compatEdits
+void WriteManifest(const std::string& name) {
+  leveldb::VersionEdit snapshot;
+  snapshot.SetComparatorName(leveldb::BytewiseComparator()->Name());
+
+  leveldb::VersionEdit edit;
+  edit.SetLogNumber(6);
+  edit.SetPrevLogNumber(0);
+  edit.SetNextFile(7);
+  edit.SetLastSequence(203);
+  edit.SetCompactPointer(1, InternalKey("key100", 150, leveldb::kTypeValue));
+  edit.RemoveFile(2, 9);
+  edit.RemoveFile(1, 8);
+  edit.AddFile(0, 5, 2806, InternalKey("key000", 201, leveldb::kTypeValue),
+               InternalKey("key200", 203, leveldb::kTypeValue));
+  edit.AddFile(1, 4, 1000, InternalKey("a", 7, leveldb::kTypeDeletion),
+               InternalKey("b", 3, leveldb::kTypeValue));
+
+  leveldb::WritableFile* file = NewFile(name);
+  leveldb::log::Writer writer(file);
+  for (const leveldb::VersionEdit* e : {&snapshot, &edit}) {
+    std::string record;
+    e->EncodeTo(&record);
+    Check(writer.AddRecord(record));
+  }
+  CloseFile(file);
+}
+
+// db/compat_test.go中的compatSession1Batches和compatSession2Batches
+void WriteDb(const std::string& dbname) {
+  leveldb::Options options;
+  options.create_if_missing = true;
+  options.error_if_exists = true;
+  leveldb::DB* db;
+  Check(leveldb::DB::Open(options, dbname, &db));
+  char key[32], value[32];
+  for (int i = 0; i < 200; i++) {
+    std::snprintf(key, sizeof(key), "key%03d", i);
+    std::snprintf(value, sizeof(value), "value%03d-", i);
+    leveldb::WriteBatch batch;
+    batch.Put(key, Repeat(value, 1 + i % 4));
+    Check(db->Write(leveldb::WriteOptions(), &batch));
+  }
+  leveldb::WriteBatch last;
+  last.Put("key000", "updated");
+  last.Delete("key001");
+  last.Put("key200", "new");
+  Check(db->Write(leveldb::WriteOptions(), &last));
+  delete db;
+
+  // 重新打开时上一次的wal写成L0的sstable（默认snappy压缩），这次的写入留在新的wal中
+  options.error_if_exists = false;
+  Check(leveldb::DB::Open(options, dbname, &db));
+  leveldb::WriteBatch first;
+  first.Delete("key002");
+  first.Put("key003", "session2");
+  Check(db->Write(leveldb::WriteOptions(), &first));
+  leveldb::WriteBatch second;
+  second.Put("big", Pattern(50000));
+  Check(db->Write(leveldb::WriteOptions(), &second));
+  delete db;
+
+  // 日志里有时间，每次生成都不一样，不需要提交
+  std::remove((dbname + "/LOG").c_str());
+  std::remove((dbname + "/LOG.old").c_str());
+}
+
+}  // namespace
+
+int main(int argc, char** argv) {
+  std::string dir = argc > 1 ? argv[1] : ".";
+  const leveldb::FilterPolicy* bloom = leveldb::NewBloomFilterPolicy(10);
+  WriteTable(dir + "/table.ldb", leveldb::kNoCompression, nullptr);
+  WriteTable(dir + "/table_snappy.ldb", leveldb::kSnappyCompression, nullptr);
+  WriteTable(dir + "/table_bloom.ldb", leveldb::kNoCompression, bloom);
+  WriteLog(dir + "/records.log");
+  WriteManifest(dir + "/manifest");
+  WriteDb(dir + "/db");
+  delete bloom;
+  return 0;
+}
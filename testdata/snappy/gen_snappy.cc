@@ -0,0 +1,61 @@
+// 用c++版本的snappy库生成compress包测试用的文件，每个输入写两个文件：
+//   NAME.raw     原始数据
+//   NAME.snappy  snappy::Compress的结果
+// 编译和运行的命令见同目录的README.md
+#include <cstdint>
+#include <cstdio>
+#include <cstdlib>
+#include <fstream>
+#include <string>
+
+#include "snappy.h"
+
+static void WriteFile(const std::string& name, const std::string& contents) {
+  std::ofstream out(name, std::ios::binary);
+  out.write(contents.data(), contents.size());
+  if (!out) {
+    std::fprintf(stderr, "write %s failed\n", name.c_str());
+    std::exit(1);
+  }
+}
+
+static void Generate(const std::string& dir, const std::string& name,
+                     const std::string& raw) {
+  std::string compressed;
+  snappy::Compress(raw.data(), raw.size(), &compressed);
+  WriteFile(dir + "/" + name + ".raw", raw);
+  WriteFile(dir + "/" + name + ".snappy", compressed);
+}
+
+int main(int argc, char** argv) {
+  std::string dir = argc > 1 ? argv[1] : ".";
+
+  // 像sstable data block一样前缀相同的key和value
+  std::string text;
+  char buf[64];
+  for (int i = 0; i < 2000; i++) {
+    std::snprintf(buf, sizeof(buf), "key%06d=value%d\n", i, i % 97);
+    text += buf;
+  }
+  Generate(dir, "text", text);
+
+  // 压缩不了的数据，全部是literal
+  std::string random;
+  uint32_t x = 301;
+  for (int i = 0; i < 40000; i++) {
+    x = x * 1103515245 + 12345;
+    random.push_back(static_cast<char>(x >> 24));
+  }
+  Generate(dir, "random", random);
+
+  // 超过64KB，snappy分成多个fragment压缩
+  std::string pattern;
+  for (int i = 0; i < 70000; i++) {
+    pattern.push_back(static_cast<char>(i % 251));
+  }
+  Generate(dir, "pattern", pattern);
+
+  Generate(dir, "zeros", std::string(70000, '\0'));
+  Generate(dir, "empty", "");
+  return 0;
+}
@@ -1,10 +1,10 @@
 package version
 
 import (
-	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
+	"math"
+	"os"
 
 	"github.com/merlin82/leveldb/internal"
 	"github.com/merlin82/leveldb/memtable"
@@ -14,6 +14,23 @@ import (
 type Compaction struct {
 	level  int
 	inputs [2][]*FileMetaData
+	// 选择输入文件时的version，合并过程中被引用住
+	inputVersion *Version
+	// 合并的结果，由调用方通过LogAndApply生效
+	edit VersionEdit
+}
+
+func (c *Compaction) Edit() *VersionEdit {
+	return &c.edit
+}
+
+// 合并结束后释放对inputVersion的引用
+// REQUIRES: db.mu is held
+func (c *Compaction) ReleaseInputs() {
+	if c.inputVersion != nil {
+		c.inputVersion.Unref()
+		c.inputVersion = nil
+	}
 }
 
 //可以直接把文件下移
@@ -37,73 +54,12 @@ func (c *Compaction) Log() {
 	log.Printf("inputs[1]: %s\n", ss)
 }
 
-func (meta *FileMetaData) EncodeTo(w io.Writer) error {
-	binary.Write(w, binary.LittleEndian, meta.allowSeeks)
-	binary.Write(w, binary.LittleEndian, meta.fileSize)
-	binary.Write(w, binary.LittleEndian, meta.number)
-	meta.smallest.EncodeTo(w)
-	meta.largest.EncodeTo(w)
-	return nil
-}
-
-func (meta *FileMetaData) DecodeFrom(r io.Reader) error {
-	binary.Read(r, binary.LittleEndian, &meta.allowSeeks)
-	binary.Read(r, binary.LittleEndian, &meta.fileSize)
-	binary.Read(r, binary.LittleEndian, &meta.number)
-	meta.smallest = new(internal.InternalKey)
-	meta.smallest.DecodeFrom(r)
-	meta.largest = new(internal.InternalKey)
-	meta.largest.DecodeFrom(r)
-	return nil
-}
-
-//当前version信息写到MANIFEST-xxxx文件里面；
-//记录内容为：
-//	1.下一个文件的id
-//	2.当前最新的lsn
-//	文件层级关系
-//		3.文件数
-//		每个文件的原信息
-//      	4.文件大小
-//      	5.文件序号
-//      	6.文件最大值
-//      	7.文件最小值
-func (v *Version) EncodeTo(w io.Writer) error {
-	binary.Write(w, binary.LittleEndian, v.nextFileNumber)
-	binary.Write(w, binary.LittleEndian, v.seq)
-	for level := 0; level < internal.NumLevels; level++ {
-		numFiles := len(v.files[level])
-		binary.Write(w, binary.LittleEndian, int32(numFiles))
-
-		for i := 0; i < numFiles; i++ {
-			v.files[level][i].EncodeTo(w)
-		}
-	}
-	return nil
-}
-
-func (v *Version) DecodeFrom(r io.Reader) error {
-	binary.Read(r, binary.LittleEndian, &v.nextFileNumber)
-	binary.Read(r, binary.LittleEndian, &v.seq)
-	var numFiles int32
-	for level := 0; level < internal.NumLevels; level++ {
-		binary.Read(r, binary.LittleEndian, &numFiles)
-		v.files[level] = make([]*FileMetaData, numFiles)
-		for i := 0; i < int(numFiles); i++ {
-			var meta FileMetaData
-			meta.DecodeFrom(r)
-			v.files[level][i] = &meta
-		}
-	}
-	return nil
-}
-
-func (v *Version) deleteFile(level int, meta *FileMetaData) {
+func (v *Version) deleteFile(level int, number uint64) {
 	numFiles := len(v.files[level])
 	for i := 0; i < numFiles; i++ {
-		if v.files[level][i].number == meta.number {
+		if v.files[level][i].number == number {
 			v.files[level] = append(v.files[level][:i], v.files[level][i+1:]...)
-			log.Printf("deleteFile, level:%d, num:%d", level, meta.number)
+			log.Printf("deleteFile, level:%d, num:%d", level, number)
 			break
 		}
 	}
@@ -116,7 +72,7 @@ func (v *Version) addFile(level int, meta *FileMetaData) {
 		v.files[level] = append(v.files[level], meta)
 	} else {
 		numFiles := len(v.files[level])
-		index := v.findFile(v.files[level], meta.smallest.UserKey)
+		index := findFile(v.files[level], meta.smallest.UserKey)
 		if index >= numFiles {
 			v.files[level] = append(v.files[level], meta)
 		} else {
@@ -128,41 +84,48 @@ func (v *Version) addFile(level int, meta *FileMetaData) {
 	}
 }
 
-func (v *Version) WriteLevel0Table(imm *memtable.MemTable) {
-	var meta FileMetaData
-	meta.allowSeeks = 1 << 30 // 1GB
-	meta.number = v.nextFileNumber
-	v.nextFileNumber++
-	// sstable内存形式
-	builder := sstable.NewTableBuilder((internal.TableFileName(v.tableCache.dbName, meta.number)))
+// imm写到文件号为number的sstable，根据v挑选不超过maxLevel的level，新文件记录到edit里面，由调用方通过LogAndApply生效
+// 不修改v，调用方引用住v以后可以不持有锁
+// 写文件失败时删除写了一半的文件，edit不变
+func (v *Version) WriteLevel0Table(imm *memtable.MemTable, number uint64, edit *VersionEdit, maxLevel int) error {
 	iter := imm.NewIterator()
 	iter.SeekToFirst()
-	if iter.Valid() {
-		// 先把imm写到内存，4k刷盘一次
-		meta.smallest = iter.InternalKey()
-		for ; iter.Valid(); iter.Next() {
-			meta.largest = iter.InternalKey()
-			builder.Add(iter.InternalKey())
-		}
-		// 落盘； data(最后一块刷盘) + index + footer 三部分
-		builder.Finish()
-		meta.fileSize = uint64(builder.FileSize())
-		meta.smallest.UserValue = nil
-		meta.largest.UserValue = nil
+	if !iter.Valid() {
+		return nil
+	}
+	// sstable内存形式
+	fileName := internal.TableFileName(v.tableCache.dbName, number)
+	builder, err := sstable.NewTableBuilder(fileName, v.tableCache.opts)
+	if err != nil {
+		return err
+	}
+	// 先把imm写到内存，4k刷盘一次
+	// mem的迭代器返回的key在迭代器移动以后仍然有效
+	smallest := iter.InternalKey()
+	var largest *internal.InternalKey
+	for ; iter.Valid(); iter.Next() {
+		largest = iter.InternalKey()
+		builder.Add(iter.InternalKey())
+	}
+	// 落盘； data(最后一块刷盘) + index + footer 三部分
+	if err := builder.Finish(); err != nil {
+		os.Remove(fileName)
+		return err
 	}
 
 	// 挑选合适的level
 	level := 0
-	if !v.overlapInLevel(0, meta.smallest.UserKey, meta.largest.UserKey) {
-		for ; level < internal.MaxMemCompactLevel; level++ {
-			if v.overlapInLevel(level+1, meta.smallest.UserKey, meta.largest.UserKey) {
+	if !v.overlapInLevel(0, smallest.UserKey, largest.UserKey) {
+		for ; level < maxLevel; level++ {
+			if v.overlapInLevel(level+1, smallest.UserKey, largest.UserKey) {
 				break
 			}
 		}
 	}
 
 	// 因为imm已经写到文件，version维护的sstable信息需要更新
-	v.addFile(level, &meta)
+	edit.AddFile(level, number, builder.FileSize(), smallest, largest)
+	return nil
 }
 
 func (v *Version) overlapInLevel(level int, smallestKey, largestKey []byte) bool {
@@ -180,122 +143,179 @@ func (v *Version) overlapInLevel(level int, smallestKey, largestKey []byte) bool
 			}
 		}
 	} else {
-		index := v.findFile(v.files[level], smallestKey)
+		index := findFile(v.files[level], smallestKey)
 		if index >= numFiles {
 			return false
 		}
-		if internal.UserKeyComparator(largestKey, v.files[level][index].smallest.UserKey) > 0 {
+		if internal.UserKeyComparator(largestKey, v.files[level][index].smallest.UserKey) >= 0 {
 			return true
 		}
 	}
 	return false
 }
 
-func (v *Version) DoCompactionWork() bool {
-	// 选择要合并的level和sstable文件
-	c := v.pickCompaction()
-	if c == nil {
-		return false
-	}
+// 合并输入文件，结果记录到c.Edit()里面
+// 只读取c.inputVersion，不需要持有锁；newFileNumber用来分配新文件号，由调用方负责加锁
+// smallestSnapshot是最老的快照的sequence，没有快照时是lastSequence，
+// 快照还能看到的版本都要保留
+// 合并可能很久，每处理一个key之前调用一次compactMemTable，让调用方先把等待中的imm刷盘，可以为nil
+// 出错时返回错误，c.Edit()不能再使用
+func (c *Compaction) DoCompactionWork(newFileNumber func() uint64, smallestSnapshot uint64, compactMemTable func()) error {
 	log.Printf("DoCompactionWork begin\n")
 	defer log.Printf("DoCompactionWork end\n")
 
 	// 打日志，merge的文件
 	c.Log()
 
+	edit := &c.edit
 	// 先判断是否可以直接下移一层，如果有直接下移，都是内存操作，如果崩溃也没事
 	// 判断时如果上一层是1个文件，下一层没有文件，可以直接下移
 	if c.isTrivialMove() {
-		v.deleteFile(c.level, c.inputs[0][0])
-		v.addFile(c.level+1, c.inputs[0][0])
-		return true
+		f := c.inputs[0][0]
+		edit.DeleteFile(c.level, f.number)
+		edit.AddFile(c.level+1, f.number, f.fileSize, f.smallest, f.largest)
+		return nil
 	}
 
-	// 合并后生成的新文件
-	var list []*FileMetaData
-	var current_key *internal.InternalKey
-
 	// sstable迭代器
-	iter := v.makeInputIterator(c)
+	iter := c.inputVersion.makeInputIterator(c)
+	defer iter.Close()
+
+	var builder *sstable.TableBuilder
+	var number uint64
+	// 所有输出文件，出错时全部删除
+	var outputs []uint64
+	// 当前输出文件中最小和最大的key
+	var smallest, largest *internal.InternalKey
+	var currentUserKey []byte
+	hasCurrentUserKey := false
+	var lastSequenceForKey uint64
 
-	// 从最小的sstable开始，每个行记录为维度向后merge
+	// 归并排序，同一个user key的版本按sequence从大到小出现
+	//    某个版本之后更新的版本所有快照都能看到，这个版本可以丢掉
+	//    删除标记所有快照都能看到，并且更深的层没有这个key，删除标记也可以丢掉
 	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
-		var meta FileMetaData
-		meta.allowSeeks = 1 << 30
-		meta.number = v.nextFileNumber
-		v.nextFileNumber++
-
-		// 合并后新的sstable文件名
-		sstableFileName := internal.TableFileName(v.tableCache.dbName, meta.number)
-
-		// 创建文件，并且申请一块内存用来缓存记录
-		builder := sstable.NewTableBuilder(sstableFileName)
-
-		// 要合并的sstable中最小的key
-		meta.smallest = iter.InternalKey()
-
-		// 归并排序
-		//    大于4k刷盘一次，超过2MB切换到下一个文件，切换之前需要添加尾信息
-		for ; iter.Valid(); iter.Next() {
-			if current_key != nil {
-				// 去除重复的记录
-				ret := internal.UserKeyComparator(iter.InternalKey().UserKey, current_key.UserKey)
-				if ret == 0 {
-					log.Printf("%s == %s", string(iter.InternalKey().UserKey), string(current_key.UserKey))
-					continue
-				} else if ret < 0 {
-					log.Fatalf("%s < %s", string(iter.InternalKey().UserKey), string(current_key.UserKey))
+		if compactMemTable != nil {
+			compactMemTable()
+		}
+		key := iter.InternalKey()
+		if !hasCurrentUserKey || internal.UserKeyComparator(key.UserKey, currentUserKey) != 0 {
+			// 第一次出现这个user key，迭代器移动以后key会失效，需要复制
+			currentUserKey = append(currentUserKey[:0], key.UserKey...)
+			hasCurrentUserKey = true
+			lastSequenceForKey = math.MaxUint64
+			// 单个sstable文件超过大小就切换到新文件，同一个user key的所有版本放在同一个文件中
+			if builder != nil && builder.FileSize() > internal.MaxFileSize {
+				err := c.finishOutput(builder, number, smallest, largest)
+				builder = nil
+				if err != nil {
+					return c.abandonOutputs(outputs, err)
 				}
 			}
-			current_key = iter.InternalKey()
-			meta.largest = iter.InternalKey()
+		}
 
-			// 4KB刷盘一次
-			builder.Add(iter.InternalKey())
+		drop := false
+		if lastSequenceForKey <= smallestSnapshot {
+			// 更新的版本已经对所有快照可见
+			drop = true
+		} else if key.Type == internal.TypeDeletion && key.Seq <= smallestSnapshot && c.isBaseLevelForKey(key.UserKey) {
+			// 更早的版本都在这次合并的输入文件中，会被上面的规则丢掉
+			drop = true
+		}
+		lastSequenceForKey = key.Seq
+		if drop {
+			continue
+		}
 
-			// 单个sstable文件最大2MB，超过就添加新文件
-			if builder.FileSize() > internal.MaxFileSize {
-				break
+		if builder == nil {
+			number = newFileNumber()
+			var err error
+			builder, err = sstable.NewTableBuilder(internal.TableFileName(c.inputVersion.tableCache.dbName, number), c.inputVersion.tableCache.opts)
+			if err != nil {
+				return c.abandonOutputs(outputs, err)
 			}
+			outputs = append(outputs, number)
+			smallest = internal.NewInternalKey(key.Seq, key.Type, key.UserKey, nil)
+			largest = new(internal.InternalKey)
+		}
+		largest.Seq = key.Seq
+		largest.Type = key.Type
+		largest.UserKey = append(largest.UserKey[:0], key.UserKey...)
+		// 4KB刷盘一次
+		builder.Add(key)
+	}
+	// 输入文件读取失败时跳过了出错的数据，不能删除输入文件
+	if err := iter.Error(); err != nil {
+		if builder != nil {
+			builder.Abandon()
+		}
+		return c.abandonOutputs(outputs, err)
+	}
+	if builder != nil {
+		if err := c.finishOutput(builder, number, smallest, largest); err != nil {
+			return c.abandonOutputs(outputs, err)
 		}
-
-		// 添加尾信息
-		builder.Finish()
-
-		// 新文件生成了，记录文件元信息
-		meta.fileSize = uint64(builder.FileSize())
-		meta.smallest.UserValue = nil
-		meta.largest.UserValue = nil
-
-		// 添加到新文件列表，因为后面要更新version
-		list = append(list, &meta)
 	}
 
-	// 从version中删除level信息
+	// 删除合并前level和level+1的文件
 	for i := 0; i < len(c.inputs[0]); i++ {
-		v.deleteFile(c.level, c.inputs[0][i])
+		edit.DeleteFile(c.level, c.inputs[0][i].number)
 	}
-
-	// 从version中删除level+1信息
 	for i := 0; i < len(c.inputs[1]); i++ {
-		v.deleteFile(c.level+1, c.inputs[1][i])
+		edit.DeleteFile(c.level+1, c.inputs[1][i].number)
+	}
+	return nil
+}
+
+// 添加尾信息，在level+1中添加新文件
+func (c *Compaction) finishOutput(builder *sstable.TableBuilder, number uint64, smallest, largest *internal.InternalKey) error {
+	if err := builder.Finish(); err != nil {
+		return err
 	}
+	c.edit.AddFile(c.level+1, number, builder.FileSize(), smallest, largest)
+	return nil
+}
 
-	// 在version中添加新的level+1信息
-	for i := 0; i < len(list); i++ {
-		v.addFile(c.level+1, list[i])
+// 合并失败，删除已经写的输出文件，返回err
+func (c *Compaction) abandonOutputs(outputs []uint64, err error) error {
+	for _, number := range outputs {
+		os.Remove(internal.TableFileName(c.inputVersion.tableCache.dbName, number))
 	}
+	return err
+}
 
+// level+2以及更深的层中都没有这个user key
+func (c *Compaction) isBaseLevelForKey(userKey []byte) bool {
+	v := c.inputVersion
+	for level := c.level + 2; level < internal.NumLevels; level++ {
+		files := v.files[level]
+		index := findFile(files, userKey)
+		if index < len(files) && internal.UserKeyComparator(userKey, files[index].smallest.UserKey) >= 0 {
+			return false
+		}
+	}
 	return true
 }
 
+// L0的文件可能互相重叠，每个文件一个迭代器；L1以上的文件整层用一个迭代器，按顺序打开
 func (v *Version) makeInputIterator(c *Compaction) *MergingIterator {
-	var list []*sstable.Iterator
-	for i := 0; i < len(c.inputs[0]); i++ {
-		list = append(list, v.tableCache.NewIterator(c.inputs[0][i].number))
-	}
-	for i := 0; i < len(c.inputs[1]); i++ {
-		list = append(list, v.tableCache.NewIterator(c.inputs[1][i].number))
+	var list []internal.Iterator
+	for which := 0; which < 2; which++ {
+		if len(c.inputs[which]) == 0 {
+			continue
+		}
+		if c.level+which == 0 {
+			for _, f := range c.inputs[which] {
+				it, err := v.tableCache.NewIterator(f.number, nil)
+				if err != nil {
+					list = append(list, newErrorIterator(err))
+					continue
+				}
+				list = append(list, it)
+			}
+		} else {
+			list = append(list, v.newConcatenatingIterator(c.inputs[which], nil, nil))
+		}
 	}
 	return NewMergingIterator(list)
 }
@@ -305,7 +325,10 @@ func (v *Version) makeInputIterator(c *Compaction) *MergingIterator {
 //       所有文件合并到L1中，
 //    如果是L1以上
 //       选择一个 Level-N 文件，找到所有和该 Level-N 有重复 Key 的 Level-(N+1) 文件进行合并。
-func (v *Version) pickCompaction() *Compaction {
+// 需要合并时返回选好的输入文件，同时引用住当前version
+// REQUIRES: db.mu is held
+func (vs *VersionSet) PickCompaction() *Compaction {
+	v := vs.current
 	var c Compaction
 	// 根据文件大小、或者文件个数判断超出规定的level进行压缩
 	c.level = v.pickCompactionLevel()
@@ -332,7 +355,7 @@ func (v *Version) pickCompaction() *Compaction {
 		// Pick the first file that comes after compact_pointer_[level]
 		for i := 0; i < len(v.files[c.level]); i++ {
 			f := v.files[c.level][i]
-			if v.compactPointer[c.level] == nil || internal.InternalKeyComparator(f.largest, v.compactPointer[c.level]) > 0 {
+			if vs.compactPointer[c.level] == nil || internal.InternalKeyComparator(f.largest, vs.compactPointer[c.level]) > 0 {
 				c.inputs[0] = append(c.inputs[0], f)
 				break
 			}
@@ -347,12 +370,21 @@ func (v *Version) pickCompaction() *Compaction {
 	//选择一个 Level-N 文件，找到所有和该 Level-N 有重复 Key 的 Level-(N+1) 文件进行合并。
 	for i := 0; i < len(v.files[c.level+1]); i++ {
 		f := v.files[c.level+1][i]
-		if internal.InternalKeyComparator(f.largest, smallest) < 0 || internal.InternalKeyComparator(f.smallest, largest) > 0 {
+		// 按user key判断，同一个user key的不同版本必须一起合并，否则会分散到下一层相邻的两个文件中
+		if internal.UserKeyComparator(f.largest.UserKey, smallest.UserKey) < 0 || internal.UserKeyComparator(f.smallest.UserKey, largest.UserKey) > 0 {
 			// "f" is completely before specified range; skip it,  // "f" is completely after specified range; skip it
 		} else {
 			c.inputs[1] = append(c.inputs[1], f)
 		}
 	}
+
+	// 下次这一层从这个位置之后开始合并
+	// 这里直接更新，不等到LogAndApply，合并失败时下次也会尝试其他的文件
+	vs.compactPointer[c.level] = largest
+	c.edit.SetCompactPointer(c.level, largest)
+
+	c.inputVersion = v
+	v.Ref()
 	return &c
 }
 
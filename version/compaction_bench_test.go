@@ -0,0 +1,92 @@
+package version
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+const (
+	benchNumFiles = 16
+	benchNumKeys  = 2000
+)
+
+// 生成benchNumFiles个互相重叠的L0文件，返回最大的sequence
+func setupBenchLevel0(b *testing.B, vs *VersionSet) uint64 {
+	var edit VersionEdit
+	seq := uint64(0)
+	for i := 0; i < benchNumFiles; i++ {
+		var keys []*internal.InternalKey
+		for j := i; j < benchNumKeys*benchNumFiles; j += benchNumFiles {
+			seq++
+			keys = append(keys, internal.NewInternalKey(seq, internal.TypeValue, []byte(fmt.Sprintf("%08d", j)), []byte("value")))
+		}
+		addTestTable(b, vs, &edit, 0, vs.NewFileNumber(), keys)
+	}
+	if err := vs.LogAndApply(&edit); err != nil {
+		b.Fatal(err)
+	}
+	return seq
+}
+
+func newBenchVersionSet(b *testing.B) (*VersionSet, func()) {
+	log.SetOutput(ioutil.Discard)
+	dbName, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		b.Fatal(err)
+	}
+	vs := NewVersionSet(dbName, nil)
+	return vs, func() {
+		vs.Close()
+		os.RemoveAll(dbName)
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// 16个L0文件合并到L1
+func BenchmarkCompaction_L0(b *testing.B) {
+	vs, cleanup := newBenchVersionSet(b)
+	defer cleanup()
+	seq := setupBenchLevel0(b, vs)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v := vs.Current()
+		v.Ref()
+		c := &Compaction{level: 0, inputVersion: v}
+		c.inputs[0] = v.files[0]
+		if err := c.DoCompactionWork(vs.NewFileNumber, seq, nil); err != nil {
+			b.Fatal(err)
+		}
+
+		// 输出不生效，删掉以后下一轮还是同样的输入，磁盘占用也不会一直增长
+		b.StopTimer()
+		c.ReleaseInputs()
+		for _, entry := range c.Edit().newFiles {
+			os.Remove(internal.TableFileName(vs.dbName, entry.meta.number))
+		}
+		b.StartTimer()
+	}
+}
+
+// 只归并16个L0文件，不写新文件
+func BenchmarkMergingIterator_L0(b *testing.B) {
+	vs, cleanup := newBenchVersionSet(b)
+	defer cleanup()
+	setupBenchLevel0(b, vs)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v := vs.Current()
+		c := &Compaction{level: 0, inputVersion: v}
+		c.inputs[0] = v.files[0]
+		iter := v.makeInputIterator(c)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		}
+		iter.Close()
+	}
+}
@@ -0,0 +1,133 @@
+package version
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/sstable"
+)
+
+// 把keys写成文件号为number的sstable，并添加到level中
+func addTestTable(t testing.TB, vs *VersionSet, edit *VersionEdit, level int, number uint64, keys []*internal.InternalKey) {
+	builder, err := sstable.NewTableBuilder(internal.TableFileName(vs.dbName, number), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		builder.Add(key)
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	edit.AddFile(level, number, builder.FileSize(), keys[0], keys[len(keys)-1])
+	vs.MarkFileNumberUsed(number)
+}
+
+func Test_Compaction_DropObsolete(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+
+	var edit VersionEdit
+	addTestTable(t, vs, &edit, 1, 1, []*internal.InternalKey{
+		internal.NewInternalKey(5, internal.TypeDeletion, []byte("a"), nil),
+		internal.NewInternalKey(6, internal.TypeValue, []byte("b"), []byte("b2")),
+		internal.NewInternalKey(3, internal.TypeValue, []byte("c"), []byte("c1")),
+	})
+	addTestTable(t, vs, &edit, 2, 2, []*internal.InternalKey{
+		internal.NewInternalKey(1, internal.TypeValue, []byte("a"), []byte("a1")),
+		internal.NewInternalKey(2, internal.TypeValue, []byte("b"), []byte("b1")),
+	})
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		smallestSnapshot uint64
+		expected         string
+	}{
+		// 没有快照，旧版本和删除标记都丢掉
+		{10, "b@6 c@3 "},
+		// 快照4还能看到a1和b1
+		{4, "a@5 a@1 b@6 b@2 c@3 "},
+		// 快照5看到的是删除标记，a1已经不可见
+		{5, "b@6 b@2 c@3 "},
+	}
+	for _, test := range tests {
+		v := vs.Current()
+		c := &Compaction{level: 1, inputVersion: v}
+		c.inputs[0] = v.files[1]
+		c.inputs[1] = v.files[2]
+		if err := c.DoCompactionWork(vs.NewFileNumber, test.smallestSnapshot, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		for _, entry := range c.edit.newFiles {
+			it, err := vs.tableCache.NewIterator(entry.meta.number, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for it.SeekToFirst(); it.Valid(); it.Next() {
+				got += fmt.Sprintf("%s@%d ", it.InternalKey().UserKey, it.InternalKey().Seq)
+			}
+		}
+		if got != test.expected {
+			t.Errorf("smallestSnapshot %d: got %q, want %q", test.smallestSnapshot, got, test.expected)
+		}
+	}
+}
+
+func Test_Compaction_InputError(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+
+	var edit VersionEdit
+	addTestTable(t, vs, &edit, 1, 1, []*internal.InternalKey{
+		internal.NewInternalKey(3, internal.TypeValue, []byte("a"), []byte("a2")),
+		internal.NewInternalKey(4, internal.TypeValue, []byte("c"), []byte("c2")),
+	})
+	addTestTable(t, vs, &edit, 2, 2, []*internal.InternalKey{
+		internal.NewInternalKey(1, internal.TypeValue, []byte("a"), []byte("a1")),
+	})
+	addTestTable(t, vs, &edit, 2, 3, []*internal.InternalKey{
+		internal.NewInternalKey(2, internal.TypeValue, []byte("b"), []byte("b1")),
+	})
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	// 读不到L2的一个输入文件，合并不能只输出读到的部分
+	if err := os.Remove(internal.TableFileName(dbName, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	v := vs.Current()
+	c := &Compaction{level: 1, inputVersion: v}
+	c.inputs[0] = v.files[1]
+	c.inputs[1] = v.files[2]
+	var outputs []uint64
+	newFileNumber := func() uint64 {
+		number := vs.NewFileNumber()
+		outputs = append(outputs, number)
+		return number
+	}
+	if err := c.DoCompactionWork(newFileNumber, 10, nil); err == nil {
+		t.Fatal("DoCompactionWork succeeded with a missing input")
+	}
+	if len(c.edit.deletedFiles) != 0 {
+		t.Fatal("inputs deleted:", c.edit.deletedFiles)
+	}
+	if len(outputs) == 0 {
+		t.Fatal("no output written")
+	}
+	for _, number := range outputs {
+		if _, err := os.Stat(internal.TableFileName(dbName, number)); !os.IsNotExist(err) {
+			t.Fatal("output not removed:", number, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package version
+
+import (
+	"github.com/merlin82/leveldb/internal"
+)
+
+// 打开sstable失败时代替它的迭代器，没有任何数据，Error()返回打开时的错误
+type errorIterator struct {
+	err error
+}
+
+func newErrorIterator(err error) *errorIterator {
+	return &errorIterator{err: err}
+}
+
+func (it *errorIterator) Valid() bool {
+	return false
+}
+
+func (it *errorIterator) InternalKey() *internal.InternalKey {
+	return nil
+}
+
+func (it *errorIterator) Next() {}
+
+func (it *errorIterator) Prev() {}
+
+func (it *errorIterator) Seek(target *internal.InternalKey) {}
+
+func (it *errorIterator) SeekToFirst() {}
+
+func (it *errorIterator) SeekToLast() {}
+
+func (it *errorIterator) Error() error {
+	return it.err
+}
@@ -0,0 +1,120 @@
+package version
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+// 以前的版本每次修改都把整个version写到一个新的MANIFEST，CURRENT里面只有这个文件的编号。
+// 打开这样的db时读出快照，写成新的MANIFEST并切换CURRENT，之后和普通的db一样。
+// 旧的MANIFEST在切换以后作为无用文件删除。
+// REQUIRES: vs是刚创建的，还没有Recover
+func (vs *VersionSet) recoverLegacy(manifestFileNumber uint64) error {
+	p, err := ioutil.ReadFile(internal.DescriptorFileName(vs.dbName, manifestFileNumber))
+	if err != nil {
+		return err
+	}
+	var edit VersionEdit
+	if err := decodeLegacyManifest(p, &edit); err != nil {
+		return err
+	}
+	vs.appendVersion(vs.current.apply(&edit))
+	vs.nextFileNumber = edit.nextFileNumber
+	vs.MarkFileNumberUsed(manifestFileNumber)
+	for _, entry := range edit.newFiles {
+		vs.MarkFileNumberUsed(entry.meta.number)
+	}
+	vs.lastSequence = edit.lastSequence
+
+	// 以前的版本没有wal，logNumber为0
+	// manifestLog为nil，LogAndApply会新建MANIFEST，先写入当前version的快照，成功后再写CURRENT
+	return vs.LogAndApply(&VersionEdit{})
+}
+
+// 旧格式全部是小端的定长整数：
+//    nextFileNumber(uint64) seq(uint64)
+//    每一层：文件个数(int32)，然后是每个文件
+//       allowSeeks(uint64) fileSize(uint64) number(uint64) smallest largest
+//    key：seq(uint64) type(int8) user key长度(int32) user key value长度(int32) value
+func decodeLegacyManifest(p []byte, edit *VersionEdit) error {
+	d := legacyDecoder{r: bytes.NewReader(p)}
+	nextFileNumber := d.readUint64()
+	lastSequence := d.readUint64()
+	for level := 0; level < internal.NumLevels; level++ {
+		numFiles := d.readLength()
+		for i := 0; i < numFiles && d.err == nil; i++ {
+			d.readUint64() // allowSeeks，重新打开时会重置
+			fileSize := d.readUint64()
+			number := d.readUint64()
+			smallest := d.readInternalKey()
+			largest := d.readInternalKey()
+			if d.err == nil {
+				edit.AddFile(level, number, fileSize, smallest, largest)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.r.Len() != 0 {
+		return internal.ErrEditCorruption
+	}
+	edit.SetNextFile(nextFileNumber)
+	edit.SetLastSequence(lastSequence)
+	return nil
+}
+
+// 出错后后面的读取都直接返回零值，最后统一检查err
+type legacyDecoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *legacyDecoder) read(data interface{}) {
+	if d.err != nil {
+		return
+	}
+	if binary.Read(d.r, binary.LittleEndian, data) != nil {
+		d.err = internal.ErrEditCorruption
+	}
+}
+
+func (d *legacyDecoder) readUint64() uint64 {
+	var x uint64
+	d.read(&x)
+	return x
+}
+
+// 长度不会超过剩下的字节数，被写坏时不能直接按它分配内存
+func (d *legacyDecoder) readLength() int {
+	var n int32
+	d.read(&n)
+	if d.err == nil && (n < 0 || int64(n) > int64(d.r.Len())) {
+		d.err = internal.ErrEditCorruption
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *legacyDecoder) readBytes() []byte {
+	p := make([]byte, d.readLength())
+	d.read(p)
+	return p
+}
+
+func (d *legacyDecoder) readInternalKey() *internal.InternalKey {
+	var key internal.InternalKey
+	key.Seq = d.readUint64()
+	d.read(&key.Type)
+	key.UserKey = d.readBytes()
+	d.readBytes() // value，sstable的边界key不需要
+	if d.err != nil {
+		return nil
+	}
+	return &key
+}
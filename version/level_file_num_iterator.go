@@ -0,0 +1,85 @@
+package version
+
+import (
+	"encoding/binary"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+// 遍历一层中互不重叠并且有序的文件
+//    key是文件的largest，value是8字节文件号+8字节文件大小
+type LevelFileNumIterator struct {
+	files []*FileMetaData
+	index int
+	key   *internal.InternalKey // 当前位置的key，移动以后重新生成
+}
+
+func newLevelFileNumIterator(files []*FileMetaData) *LevelFileNumIterator {
+	var it LevelFileNumIterator
+	it.files = files
+	it.index = len(files) // Marks as invalid
+	return &it
+}
+
+// Returns true iff the iterator is positioned at a valid node.
+func (it *LevelFileNumIterator) Valid() bool {
+	return it.index >= 0 && it.index < len(it.files)
+}
+
+func (it *LevelFileNumIterator) InternalKey() *internal.InternalKey {
+	if it.key == nil {
+		f := it.files[it.index]
+		value := make([]byte, 16)
+		binary.LittleEndian.PutUint64(value[0:], f.number)
+		binary.LittleEndian.PutUint64(value[8:], f.fileSize)
+		it.key = internal.NewInternalKey(f.largest.Seq, f.largest.Type, f.largest.UserKey, value)
+	}
+	return it.key
+}
+
+// Advances to the next position.
+// REQUIRES: Valid()
+func (it *LevelFileNumIterator) Next() {
+	it.setIndex(it.index + 1)
+}
+
+// Advances to the previous position.
+// REQUIRES: Valid()
+func (it *LevelFileNumIterator) Prev() {
+	if it.index == 0 {
+		it.setIndex(len(it.files)) // Marks as invalid
+	} else {
+		it.setIndex(it.index - 1)
+	}
+}
+
+// 第一个largest >= target的文件
+func (it *LevelFileNumIterator) Seek(target *internal.InternalKey) {
+	it.setIndex(findFile(it.files, target.UserKey))
+}
+
+func (it *LevelFileNumIterator) SeekToFirst() {
+	it.setIndex(0)
+}
+
+func (it *LevelFileNumIterator) SeekToLast() {
+	if len(it.files) == 0 {
+		it.setIndex(0)
+	} else {
+		it.setIndex(len(it.files) - 1)
+	}
+}
+
+func (it *LevelFileNumIterator) Error() error {
+	return nil
+}
+
+func (it *LevelFileNumIterator) setIndex(index int) {
+	it.index = index
+	it.key = nil
+}
+
+// 解析LevelFileNumIterator的value
+func decodeFileValue(value []byte) (number uint64, fileSize uint64) {
+	return binary.LittleEndian.Uint64(value[0:]), binary.LittleEndian.Uint64(value[8:])
+}
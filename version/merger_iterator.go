@@ -1,17 +1,60 @@
 package version
 
 import (
+	"container/heap"
+
 	"github.com/merlin82/leveldb/internal"
-	"github.com/merlin82/leveldb/sstable"
-	"log"
 )
 
+const (
+	forward = iota
+	reverse
+)
+
+// 合并多个有序的迭代器，每次从所有child中取最小（反向时最大）的key，就是归并排序的思想
+// 有效的child放在堆里，正向时是小顶堆，反向时是大顶堆，堆顶就是current
+// 同一个key不会出现在多个child中，因为每条记录的sequence都不一样
 type MergingIterator struct {
-	list    []*sstable.Iterator
-	current *sstable.Iterator
+	list      []internal.Iterator
+	heap      iteratorHeap
+	current   internal.Iterator
+	direction int
+}
+
+// 按InternalKeyComparator排序的堆，reverse为true时是大顶堆
+type iteratorHeap struct {
+	items   []internal.Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	ret := internal.InternalKeyComparator(h.items[i].InternalKey(), h.items[j].InternalKey())
+	if h.reverse {
+		return ret > 0
+	}
+	return ret < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
 }
 
-func NewMergingIterator(list []*sstable.Iterator) *MergingIterator {
+func (h *iteratorHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(internal.Iterator))
+}
+
+func (h *iteratorHeap) Pop() interface{} {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
+}
+
+func NewMergingIterator(list []internal.Iterator) *MergingIterator {
 	var iter MergingIterator
 	iter.list = list
 	return &iter
@@ -19,59 +62,144 @@ func NewMergingIterator(list []*sstable.Iterator) *MergingIterator {
 
 // Returns true iff the iterator is positioned at a valid node.
 func (it *MergingIterator) Valid() bool {
-	// 当前sstable不为空；当前sstable对应的dataIter不为空且dataIter的index有效
-	// it.current.dataIter.index ∈ [0, len(it.current.dataIter.block.items))
-	return it.current != nil && it.current.Valid()
+	return it.current != nil
 }
 
 func (it *MergingIterator) InternalKey() *internal.InternalKey {
-	// 当前sstable正在访问的记录
-	// it.current.dataIter.block.items[it.index]
 	return it.current.InternalKey()
 }
 
-// 每次从多个sstable中获取最小值，然后同步推进，就是归并排序的思想
 // Advances to the next position.
 // REQUIRES: Valid()
 func (it *MergingIterator) Next() {
-	// 因为当前值已经读取过，所以行记录后移
-	if it.current != nil {
-		it.current.Next()
+	// Ensure that all children are positioned after key().
+	// If we are moving in the forward direction, it is already
+	// true for all of the non-current children since current is
+	// the smallest child and key() == current.InternalKey().  Otherwise,
+	// we explicitly position the non-current children.
+	if it.direction != forward {
+		key := it.InternalKey()
+		for _, child := range it.list {
+			if child == it.current {
+				continue
+			}
+			child.Seek(key)
+			if child.Valid() && internal.InternalKeyComparator(key, child.InternalKey()) == 0 {
+				child.Next()
+			}
+		}
+		it.direction = forward
+		it.initHeap()
+	}
+	it.current.Next()
+	it.fixCurrent()
+}
+
+// Advances to the previous position.
+// REQUIRES: Valid()
+func (it *MergingIterator) Prev() {
+	// Ensure that all children are positioned before key().
+	// If we are moving in the reverse direction, it is already
+	// true for all of the non-current children since current is
+	// the largest child and key() == current.InternalKey().  Otherwise,
+	// we explicitly position the non-current children.
+	if it.direction != reverse {
+		key := it.InternalKey()
+		for _, child := range it.list {
+			if child == it.current {
+				continue
+			}
+			child.Seek(key)
+			if child.Valid() {
+				// Child is at first entry >= key().  Step back one to be < key()
+				child.Prev()
+			} else {
+				// Child has no entries >= key().  Position at last entry.
+				child.SeekToLast()
+			}
+		}
+		it.direction = reverse
+		it.initHeap()
+	}
+	it.current.Prev()
+	it.fixCurrent()
+}
+
+// Advance to the first entry with a key >= target
+func (it *MergingIterator) Seek(target *internal.InternalKey) {
+	for _, child := range it.list {
+		child.Seek(target)
 	}
-	// 从多个sstable中获取最小值
-	it.findSmallest()
+	it.direction = forward
+	it.initHeap()
 }
 
 // Position at the first entry in list.
 // Final state of iterator is Valid() iff list is not empty.
 func (it *MergingIterator) SeekToFirst() {
-	for i := 0; i < len(it.list); i++ {
-		it.list[i].SeekToFirst()
+	for _, child := range it.list {
+		child.SeekToFirst()
 	}
-	it.findSmallest()
-}
-
-func (it *MergingIterator) findSmallest() {
-	log.Printf("findSmallest begin")
-	defer log.Printf("findSmallest end")
-
-	var smallest *sstable.Iterator = nil
-	var small int
-	for i := 0; i < len(it.list); i++ {
-		if it.list[i].Valid() {
-			// it.list[i].dataIter != nil
-			if smallest == nil {
-				smallest = it.list[i]
-				small = i
-			} else if internal.InternalKeyComparator(smallest.InternalKey(), it.list[i].InternalKey()) > 0 {
-				smallest = it.list[i]
-				small = i
-			}
-			log.Printf(" list %d : user key is %s \n", i, it.list[i].InternalKey().UserKey)
+	it.direction = forward
+	it.initHeap()
+}
+
+// Position at the last entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (it *MergingIterator) SeekToLast() {
+	for _, child := range it.list {
+		child.SeekToLast()
+	}
+	it.direction = reverse
+	it.initHeap()
+}
+
+// 关闭所有child
+func (it *MergingIterator) Close() {
+	for _, child := range it.list {
+		internal.CloseIterator(child)
+	}
+	it.heap.items = nil
+	it.current = nil
+}
+
+// 返回第一个出错的child的错误
+func (it *MergingIterator) Error() error {
+	for _, child := range it.list {
+		if err := child.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 所有有效的child按当前方向建堆
+func (it *MergingIterator) initHeap() {
+	it.heap.items = it.heap.items[:0]
+	it.heap.reverse = it.direction == reverse
+	for _, child := range it.list {
+		if child.Valid() {
+			it.heap.items = append(it.heap.items, child)
 		}
 	}
-	if smallest != nil {
-		log.Printf("smallest is %d : user key is %s \n", small, smallest.InternalKey().UserKey)
+	heap.Init(&it.heap)
+	it.updateCurrent()
+}
+
+// 堆顶的current移动以后重新调整堆
+func (it *MergingIterator) fixCurrent() {
+	if it.current.Valid() {
+		heap.Fix(&it.heap, 0)
+	} else {
+		heap.Pop(&it.heap)
+	}
+	it.updateCurrent()
+}
+
+func (it *MergingIterator) updateCurrent() {
+	if len(it.heap.items) > 0 {
+		it.current = it.heap.items[0]
+	} else {
+		it.current = nil
 	}
-	it.current = smallest
 }
@@ -0,0 +1,63 @@
+package version
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/memtable"
+)
+
+func Test_MergingIterator(t *testing.T) {
+	// 三个memtable交错保存0~29
+	var list []internal.Iterator
+	for i := 0; i < 3; i++ {
+		memTable := memtable.New()
+		for j := i; j < 30; j += 3 {
+			memTable.Add(uint64(j), internal.TypeValue, []byte(fmt.Sprintf("%02d", j)), nil)
+		}
+		list = append(list, memTable.NewIterator())
+	}
+	it := NewMergingIterator(list)
+
+	expected := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if string(it.InternalKey().UserKey) != fmt.Sprintf("%02d", expected) {
+			t.Fatalf("forward: got %s, want %02d", it.InternalKey().UserKey, expected)
+		}
+		expected++
+	}
+	if expected != 30 {
+		t.Fatalf("forward: got %d entries", expected)
+	}
+
+	expected = 29
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if string(it.InternalKey().UserKey) != fmt.Sprintf("%02d", expected) {
+			t.Fatalf("reverse: got %s, want %02d", it.InternalKey().UserKey, expected)
+		}
+		expected--
+	}
+	if expected != -1 {
+		t.Fatalf("reverse: got %d entries", 29-expected)
+	}
+
+	// 切换方向
+	it.Seek(internal.LookupKey([]byte("15"), 100))
+	steps := []struct {
+		next     bool
+		expected string
+	}{
+		{false, "14"}, {false, "13"}, {true, "14"}, {true, "15"}, {true, "16"}, {false, "15"},
+	}
+	for _, step := range steps {
+		if step.next {
+			it.Next()
+		} else {
+			it.Prev()
+		}
+		if !it.Valid() || string(it.InternalKey().UserKey) != step.expected {
+			t.Fatalf("got %s, want %s", it.InternalKey().UserKey, step.expected)
+		}
+	}
+}
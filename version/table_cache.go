@@ -1,6 +1,7 @@
 package version
 
 import (
+	"os"
 	"sync"
 
 	"github.com/hashicorp/golang-lru"
@@ -11,31 +12,43 @@ import (
 type TableCache struct {
 	mu     sync.Mutex
 	dbName string
+	opts   *sstable.Options
 	cache  *lru.Cache
 }
 
 // sstable直接缓存到内存，一个文件4MB，缓存990个
-func NewTableCache(dbName string) *TableCache {
+// 从缓存中淘汰时释放缓存持有的引用，没有迭代器在用时关闭文件
+func NewTableCache(dbName string, opts *sstable.Options) *TableCache {
 	var tableCache TableCache
 	tableCache.dbName = dbName
-	tableCache.cache, _ = lru.New(internal.MaxOpenFiles - internal.NumNonTableCacheFiles)
+	tableCache.opts = opts
+	tableCache.cache, _ = lru.NewWithEvict(internal.MaxOpenFiles-internal.NumNonTableCacheFiles, func(key, value interface{}) {
+		value.(*sstable.SsTable).Close()
+	})
 	return &tableCache
 }
 
-// 迭代查询sstable里面的内容
-func (tableCache *TableCache) NewIterator(fileNum uint64) *sstable.Iterator {
-	table, _ := tableCache.findTable(fileNum)
-	if table != nil {
-		return table.NewIterator()
+// 迭代查询sstable里面的内容，opts为nil时使用零值
+// 迭代器引用住sstable，从缓存中淘汰以后也可以继续使用，用完以后需要调用Close
+func (tableCache *TableCache) NewIterator(fileNum uint64, opts *sstable.ReadOptions) (*sstable.Iterator, error) {
+	table, err := tableCache.findTable(fileNum)
+	if table == nil {
+		return nil, err
+	}
+	it := table.NewIterator()
+	table.Close()
+	if opts != nil {
+		it.SetReadOptions(*opts)
 	}
-	return nil
+	return it, nil
 }
 
 //通过缓存中查sstable数据，如果没有先读后加入
-func (tableCache *TableCache) Get(fileNum uint64, key []byte) ([]byte, error) {
+func (tableCache *TableCache) Get(fileNum uint64, key *internal.InternalKey, opts *sstable.ReadOptions) ([]byte, error) {
 	table, err := tableCache.findTable(fileNum)
 	if table != nil {
-		return table.Get(key)
+		defer table.Close()
+		return table.Get(key, opts)
 	}
 
 	return nil, err
@@ -43,19 +56,38 @@ func (tableCache *TableCache) Get(fileNum uint64, key []byte) ([]byte, error) {
 
 //删除缓存
 func (tableCache *TableCache) Evict(fileNum uint64) {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
 	tableCache.cache.Remove(fileNum)
 }
 
-//查数据
+// 清空缓存，关闭没有迭代器在用的sstable
+func (tableCache *TableCache) Close() {
+	tableCache.mu.Lock()
+	defer tableCache.mu.Unlock()
+	tableCache.cache.Purge()
+}
+
+//查数据，返回的sstable多持有一个引用，用完以后需要调用Close
 func (tableCache *TableCache) findTable(fileNum uint64) (*sstable.SsTable, error) {
 	tableCache.mu.Lock()
 	defer tableCache.mu.Unlock()
 	table, ok := tableCache.cache.Get(fileNum)
 	if ok {
-		return table.(*sstable.SsTable), nil
+		return table.(*sstable.SsTable).Ref(), nil
 	} else {
-		ssTable, err := sstable.Open(internal.TableFileName(tableCache.dbName, fileNum))
+		ssTable, err := sstable.Open(internal.TableFileName(tableCache.dbName, fileNum), tableCache.opts)
+		if os.IsNotExist(err) {
+			// c++版本以前的db里面是.sst文件
+			if table, sstErr := sstable.Open(internal.SSTTableFileName(tableCache.dbName, fileNum), tableCache.opts); sstErr == nil {
+				ssTable, err = table, nil
+			}
+		}
+		if err != nil {
+			// 不缓存打开失败的结果，下次重新打开
+			return nil, err
+		}
 		tableCache.cache.Add(fileNum, ssTable)
-		return ssTable, err
+		return ssTable.Ref(), nil
 	}
 }
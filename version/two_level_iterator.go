@@ -0,0 +1,167 @@
+package version
+
+import (
+	"bytes"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+// 两层迭代器：indexIter的每个value对应一段数据，用blockFunc打开这段数据的迭代器
+// 只有遍历到某段数据时才会打开它
+type TwoLevelIterator struct {
+	indexIter  internal.Iterator
+	blockFunc  func(indexValue []byte) internal.Iterator
+	dataIter   internal.Iterator
+	dataValue  []byte // dataIter对应的index value
+	upperBound []byte
+	err        error // 已经关闭的dataIter遇到的第一个错误
+}
+
+func NewTwoLevelIterator(indexIter internal.Iterator, blockFunc func(indexValue []byte) internal.Iterator) *TwoLevelIterator {
+	var it TwoLevelIterator
+	it.indexIter = indexIter
+	it.blockFunc = blockFunc
+	return &it
+}
+
+// 调用方不需要>=upperBound的key，正向遍历时不再打开完全超出范围的数据
+// 要求index key是对应数据中最大的key
+func (it *TwoLevelIterator) SetUpperBound(upperBound []byte) {
+	it.upperBound = upperBound
+}
+
+// Returns true iff the iterator is positioned at a valid node.
+func (it *TwoLevelIterator) Valid() bool {
+	return it.dataIter != nil && it.dataIter.Valid()
+}
+
+func (it *TwoLevelIterator) InternalKey() *internal.InternalKey {
+	return it.dataIter.InternalKey()
+}
+
+// Advances to the next position.
+// REQUIRES: Valid()
+func (it *TwoLevelIterator) Next() {
+	it.dataIter.Next()
+	it.skipEmptyDataBlocksForward()
+}
+
+// Advances to the previous position.
+// REQUIRES: Valid()
+func (it *TwoLevelIterator) Prev() {
+	it.dataIter.Prev()
+	it.skipEmptyDataBlocksBackward()
+}
+
+// Advance to the first entry with a key >= target
+func (it *TwoLevelIterator) Seek(target *internal.InternalKey) {
+	it.indexIter.Seek(target)
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.Seek(target)
+	}
+	it.skipEmptyDataBlocksForward()
+}
+
+// Position at the first entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (it *TwoLevelIterator) SeekToFirst() {
+	it.indexIter.SeekToFirst()
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.SeekToFirst()
+	}
+	it.skipEmptyDataBlocksForward()
+}
+
+// Position at the last entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (it *TwoLevelIterator) SeekToLast() {
+	it.indexIter.SeekToLast()
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.SeekToLast()
+	}
+	it.skipEmptyDataBlocksBackward()
+}
+
+// 依次检查indexIter、之前的dataIter和当前的dataIter
+func (it *TwoLevelIterator) Error() error {
+	if err := it.indexIter.Error(); err != nil {
+		return err
+	}
+	if it.err != nil {
+		return it.err
+	}
+	if it.dataIter != nil {
+		return it.dataIter.Error()
+	}
+	return nil
+}
+
+// 关闭indexIter和dataIter
+func (it *TwoLevelIterator) Close() {
+	it.setDataIterator(nil)
+	internal.CloseIterator(it.indexIter)
+}
+
+// 替换dataIter之前保留它的错误，并且关闭它
+func (it *TwoLevelIterator) setDataIterator(dataIter internal.Iterator) {
+	if it.dataIter != nil {
+		if it.err == nil {
+			it.err = it.dataIter.Error()
+		}
+		internal.CloseIterator(it.dataIter)
+	}
+	it.dataIter = dataIter
+}
+
+func (it *TwoLevelIterator) initDataBlock() {
+	if !it.indexIter.Valid() {
+		it.setDataIterator(nil)
+		return
+	}
+	value := it.indexIter.InternalKey().UserValue
+	if it.dataIter != nil && bytes.Equal(value, it.dataValue) {
+		// dataIter is already constructed with this iterator, so
+		// no need to change anything
+		return
+	}
+	it.setDataIterator(it.blockFunc(value))
+	it.dataValue = append(it.dataValue[:0], value...)
+}
+
+func (it *TwoLevelIterator) skipEmptyDataBlocksForward() {
+	for it.dataIter == nil || !it.dataIter.Valid() {
+		// Move to next block
+		if !it.indexIter.Valid() {
+			it.setDataIterator(nil)
+			return
+		}
+		// 后面数据的key都比当前的index key大
+		if it.upperBound != nil && internal.UserKeyComparator(it.indexIter.InternalKey().UserKey, it.upperBound) >= 0 {
+			it.setDataIterator(nil)
+			return
+		}
+		it.indexIter.Next()
+		it.initDataBlock()
+		if it.dataIter != nil {
+			it.dataIter.SeekToFirst()
+		}
+	}
+}
+
+func (it *TwoLevelIterator) skipEmptyDataBlocksBackward() {
+	for it.dataIter == nil || !it.dataIter.Valid() {
+		// Move to previous block
+		if !it.indexIter.Valid() {
+			it.setDataIterator(nil)
+			return
+		}
+		it.indexIter.Prev()
+		it.initDataBlock()
+		if it.dataIter != nil {
+			it.dataIter.SeekToLast()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package version
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+func Test_TwoLevelIterator(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+
+	// L1的4个文件，每个文件10个key
+	var edit VersionEdit
+	for i := 0; i < 4; i++ {
+		var keys []*internal.InternalKey
+		for j := i * 10; j < i*10+10; j++ {
+			keys = append(keys, internal.NewInternalKey(uint64(j+1), internal.TypeValue, []byte(fmt.Sprintf("%03d", j)), nil))
+		}
+		addTestTable(t, vs, &edit, 1, uint64(i+1), keys)
+	}
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	v := vs.Current()
+
+	opened := 0
+	it := NewTwoLevelIterator(newLevelFileNumIterator(v.files[1]), func(value []byte) internal.Iterator {
+		opened++
+		number, _ := decodeFileValue(value)
+		it, err := vs.tableCache.NewIterator(number, nil)
+		if err != nil {
+			return newErrorIterator(err)
+		}
+		return it
+	})
+
+	// 只打开key所在的文件
+	it.Seek(internal.LookupKey([]byte("025"), math.MaxUint64))
+	if !it.Valid() || string(it.InternalKey().UserKey) != "025" || opened != 1 {
+		t.Fatalf("seek: valid %v opened %d", it.Valid(), opened)
+	}
+	// 跨过文件边界
+	for i := 0; i < 5; i++ {
+		it.Next()
+	}
+	if !it.Valid() || string(it.InternalKey().UserKey) != "030" || opened != 2 {
+		t.Fatalf("next: valid %v opened %d", it.Valid(), opened)
+	}
+	it.Prev()
+	if !it.Valid() || string(it.InternalKey().UserKey) != "029" {
+		t.Fatalf("prev: valid %v", it.Valid())
+	}
+
+	expected := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if string(it.InternalKey().UserKey) != fmt.Sprintf("%03d", expected) {
+			t.Fatalf("forward: got %s", it.InternalKey().UserKey)
+		}
+		expected++
+	}
+	if expected != 40 {
+		t.Fatalf("forward: got %d entries", expected)
+	}
+	expected = 39
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if string(it.InternalKey().UserKey) != fmt.Sprintf("%03d", expected) {
+			t.Fatalf("reverse: got %s", it.InternalKey().UserKey)
+		}
+		expected--
+	}
+	if expected != -1 {
+		t.Fatalf("reverse: got %d entries", 39-expected)
+	}
+
+	it.Seek(internal.LookupKey([]byte("100"), math.MaxUint64))
+	if it.Valid() {
+		t.Fatalf("seek past the end: %s", it.InternalKey().UserKey)
+	}
+}
+
+func Test_TwoLevelIterator_UpperBound(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+
+	var edit VersionEdit
+	for i := 0; i < 4; i++ {
+		var keys []*internal.InternalKey
+		for j := i * 10; j < i*10+10; j++ {
+			keys = append(keys, internal.NewInternalKey(uint64(j+1), internal.TypeValue, []byte(fmt.Sprintf("%03d", j)), nil))
+		}
+		addTestTable(t, vs, &edit, 1, uint64(i+1), keys)
+	}
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	v := vs.Current()
+
+	opened := 0
+	it := NewTwoLevelIterator(newLevelFileNumIterator(v.files[1]), func(value []byte) internal.Iterator {
+		opened++
+		number, _ := decodeFileValue(value)
+		it, err := vs.tableCache.NewIterator(number, nil)
+		if err != nil {
+			return newErrorIterator(err)
+		}
+		return it
+	})
+	it.SetUpperBound([]byte("015"))
+	// 第二个文件的最大key已经超过upperBound，后面的文件不会打开
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 20 || opened != 2 {
+		t.Fatalf("got %d keys, opened %d files", n, opened)
+	}
+}
+
+// 打不开的文件被跳过，错误通过Error()返回
+func Test_TwoLevelIterator_Error(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+
+	var edit VersionEdit
+	for i := 0; i < 4; i++ {
+		var keys []*internal.InternalKey
+		for j := i * 10; j < i*10+10; j++ {
+			keys = append(keys, internal.NewInternalKey(uint64(j+1), internal.TypeValue, []byte(fmt.Sprintf("%03d", j)), nil))
+		}
+		addTestTable(t, vs, &edit, 1, uint64(i+1), keys)
+	}
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(internal.TableFileName(dbName, 2))
+
+	it := NewMergingIterator([]internal.Iterator{vs.Current().newConcatenatingIterator(vs.Current().files[1], nil, nil)})
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	if n != 30 || it.Error() == nil {
+		t.Fatalf("n = %d, err = %v", n, it.Error())
+	}
+}
@@ -3,10 +3,10 @@ package version
 import (
 	"fmt"
 	"log"
-	"os"
 	"sort"
 
 	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/sstable"
 )
 
 type FileMetaData struct {
@@ -22,45 +22,44 @@ func (fd *FileMetaData) Number() uint64 {
 }
 
 type Version struct {
-	tableCache     *TableCache
-	nextFileNumber uint64
-	seq            uint64 // lsn
-	files          [internal.NumLevels][]*FileMetaData
-	// Per-level key at which the next compaction at that level should start.
-	// Either an empty string, or a valid InternalKey.
-	compactPointer [internal.NumLevels]*internal.InternalKey
+	vset       *VersionSet
+	tableCache *TableCache
+	files      [internal.NumLevels][]*FileMetaData
+	// VersionSet中所有还在使用的version组成双向链表
+	next, prev *Version
+	refs       int
 }
 
-func New(dbName string) *Version {
+func newVersion(vset *VersionSet) *Version {
 	var v Version
-	v.tableCache = NewTableCache(dbName)
-	v.nextFileNumber = 1
+	v.vset = vset
+	v.tableCache = vset.tableCache
+	v.next = &v
+	v.prev = &v
 	return &v
 }
 
-func Load(dbName string, number uint64) (*Version, error) {
-	fileName := internal.DescriptorFileName(dbName, number)
-	file, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	v := New(dbName)
-	return v, v.DecodeFrom(file)
+// 读取version之前需要Ref，用完以后Unref，被引用的version中的文件不会被删除
+// REQUIRES: db.mu is held
+func (v *Version) Ref() {
+	v.refs++
 }
 
-//当前version信息写到文件中
-func (v *Version) Save() (uint64, error) {
-	tmp := v.nextFileNumber
-	fileName := internal.DescriptorFileName(v.tableCache.dbName, v.nextFileNumber)
-	v.nextFileNumber++
-	file, err := os.Create(fileName)
-	if err != nil {
-		return tmp, err
+// REQUIRES: db.mu is held
+func (v *Version) Unref() {
+	v.refs--
+	if v.refs < 0 {
+		log.Fatalf("version refs < 0")
+	}
+	if v.refs == 0 {
+		// 没有引用了，从链表中删除
+		v.prev.next = v.next
+		v.next.prev = v.prev
+		v.next = v
+		v.prev = v
 	}
-	defer file.Close()
-	return tmp, v.EncodeTo(file)
 }
+
 func (v *Version) Log() {
 	for level := 0; level < internal.NumLevels; level++ {
 		ss := ""
@@ -71,27 +70,33 @@ func (v *Version) Log() {
 	}
 }
 func (v *Version) Copy() *Version {
-	var c Version
-
-	c.tableCache = v.tableCache
-	c.nextFileNumber = v.nextFileNumber
-	c.seq = v.seq
+	c := newVersion(v.vset)
 	for level := 0; level < internal.NumLevels; level++ {
 		c.files[level] = make([]*FileMetaData, len(v.files[level]))
 		copy(c.files[level], v.files[level])
 	}
-	return &c
+	return c
 }
-func (v *Version) NextSeq() uint64 {
-	v.seq++
-	return v.seq
+
+// 在当前version的基础上应用edit，生成新的version，当前version不变
+func (v *Version) apply(edit *VersionEdit) *Version {
+	c := v.Copy()
+	for _, entry := range edit.deletedFiles {
+		c.deleteFile(entry.level, entry.number)
+	}
+	for _, entry := range edit.newFiles {
+		c.addFile(entry.level, entry.meta)
+	}
+	return c
 }
 
 func (v *Version) NumLevelFiles(l int) int {
 	return len(v.files[l])
 }
 
-func (v *Version) Get(key []byte) ([]byte, error) {
+// 查找sequence不大于lookupKey.Seq的最新记录
+func (v *Version) Get(lookupKey *internal.InternalKey, opts *sstable.ReadOptions) ([]byte, error) {
+	key := lookupKey.UserKey
 	var tmp []*FileMetaData
 	var tmp2 [1]*FileMetaData
 	var files []*FileMetaData
@@ -119,7 +124,7 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 			numFiles = len(tmp)
 			files = tmp
 		} else {
-			index := v.findFile(v.files[level], key)
+			index := findFile(v.files[level], key)
 			if index >= numFiles {
 				files = nil
 				numFiles = 0
@@ -136,7 +141,7 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 		}
 		for i := 0; i < numFiles; i++ {
 			f := files[i]
-			value, err := v.tableCache.Get(f.number, key)
+			value, err := v.tableCache.Get(f.number, lookupKey, opts)
 			if err != internal.ErrNotFound {
 				return value, err
 			}
@@ -145,7 +150,49 @@ func (v *Version) Get(key []byte) ([]byte, error) {
 	return nil, internal.ErrNotFound
 }
 
-func (v *Version) findFile(files []*FileMetaData, key []byte) int {
+// 遍历version中所有数据需要的迭代器：L0的每个文件一个，L1以上每层一个
+// 只需要[lowerBound, upperBound)范围内的key，nil表示不限制；完全在范围外的L0文件不打开
+// opts控制读取data block时是否校验checksum、是否放入block cache
+func (v *Version) AddIterators(list []internal.Iterator, lowerBound, upperBound []byte, opts *sstable.ReadOptions) []internal.Iterator {
+	for _, f := range v.files[0] {
+		if lowerBound != nil && internal.UserKeyComparator(f.largest.UserKey, lowerBound) < 0 {
+			continue
+		}
+		if upperBound != nil && internal.UserKeyComparator(f.smallest.UserKey, upperBound) >= 0 {
+			continue
+		}
+		it, err := v.tableCache.NewIterator(f.number, opts)
+		if err != nil {
+			list = append(list, newErrorIterator(err))
+			continue
+		}
+		it.SetUpperBound(upperBound)
+		list = append(list, it)
+	}
+	for level := 1; level < internal.NumLevels; level++ {
+		if len(v.files[level]) > 0 {
+			list = append(list, v.newConcatenatingIterator(v.files[level], upperBound, opts))
+		}
+	}
+	return list
+}
+
+// 按顺序遍历互不重叠的files，遍历到某个文件时才通过TableCache打开
+func (v *Version) newConcatenatingIterator(files []*FileMetaData, upperBound []byte, opts *sstable.ReadOptions) *TwoLevelIterator {
+	iter := NewTwoLevelIterator(newLevelFileNumIterator(files), func(value []byte) internal.Iterator {
+		number, _ := decodeFileValue(value)
+		it, err := v.tableCache.NewIterator(number, opts)
+		if err != nil {
+			return newErrorIterator(err)
+		}
+		it.SetUpperBound(upperBound)
+		return it
+	})
+	iter.SetUpperBound(upperBound)
+	return iter
+}
+
+func findFile(files []*FileMetaData, key []byte) int {
 	left := 0
 	right := len(files)
 	for left < right {
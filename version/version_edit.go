@@ -0,0 +1,278 @@
+package version
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+// MANIFEST里面的每条记录是一个VersionEdit，记录相对上一个version的变化
+// 编码方式和c++版本一致，每个字段是 tag varint32 + 内容
+const (
+	tagComparator     = 1
+	tagLogNumber      = 2
+	tagNextFileNumber = 3
+	tagLastSequence   = 4
+	tagCompactPointer = 5
+	tagDeletedFile    = 6
+	tagNewFile        = 7
+	// 8 was used for large value refs
+	tagPrevLogNumber = 9
+)
+
+type compactPointerEntry struct {
+	level int
+	key   *internal.InternalKey
+}
+
+type deletedFileEntry struct {
+	level  int
+	number uint64
+}
+
+type newFileEntry struct {
+	level int
+	meta  *FileMetaData
+}
+
+type VersionEdit struct {
+	comparator        string
+	logNumber         uint64
+	prevLogNumber     uint64 // 只为了和c++版本的MANIFEST一致，恢复时不使用
+	nextFileNumber    uint64
+	lastSequence      uint64
+	hasComparator     bool
+	hasLogNumber      bool
+	hasPrevLogNumber  bool
+	hasNextFileNumber bool
+	hasLastSequence   bool
+	compactPointers   []compactPointerEntry
+	deletedFiles      []deletedFileEntry
+	newFiles          []newFileEntry
+}
+
+func (edit *VersionEdit) SetComparatorName(name string) {
+	edit.hasComparator = true
+	edit.comparator = name
+}
+
+func (edit *VersionEdit) SetLogNumber(number uint64) {
+	edit.hasLogNumber = true
+	edit.logNumber = number
+}
+
+func (edit *VersionEdit) SetPrevLogNumber(number uint64) {
+	edit.hasPrevLogNumber = true
+	edit.prevLogNumber = number
+}
+
+func (edit *VersionEdit) SetNextFile(number uint64) {
+	edit.hasNextFileNumber = true
+	edit.nextFileNumber = number
+}
+
+func (edit *VersionEdit) SetLastSequence(seq uint64) {
+	edit.hasLastSequence = true
+	edit.lastSequence = seq
+}
+
+func (edit *VersionEdit) SetCompactPointer(level int, key *internal.InternalKey) {
+	edit.compactPointers = append(edit.compactPointers, compactPointerEntry{level, key})
+}
+
+func (edit *VersionEdit) DeleteFile(level int, number uint64) {
+	edit.deletedFiles = append(edit.deletedFiles, deletedFileEntry{level, number})
+}
+
+// smallest和largest只用到key，value会被忽略
+func (edit *VersionEdit) AddFile(level int, number uint64, fileSize uint64, smallest, largest *internal.InternalKey) {
+	var meta FileMetaData
+	meta.allowSeeks = 1 << 30
+	meta.number = number
+	meta.fileSize = fileSize
+	meta.smallest = internal.NewInternalKey(smallest.Seq, smallest.Type, smallest.UserKey, nil)
+	meta.largest = internal.NewInternalKey(largest.Seq, largest.Type, largest.UserKey, nil)
+	edit.newFiles = append(edit.newFiles, newFileEntry{level, &meta})
+}
+
+func (edit *VersionEdit) EncodeTo(w io.Writer) error {
+	var p []byte
+	if edit.hasComparator {
+		p = appendUvarint(p, tagComparator)
+		p = appendBytes(p, []byte(edit.comparator))
+	}
+	if edit.hasLogNumber {
+		p = appendUvarint(p, tagLogNumber)
+		p = appendUvarint(p, edit.logNumber)
+	}
+	if edit.hasPrevLogNumber {
+		p = appendUvarint(p, tagPrevLogNumber)
+		p = appendUvarint(p, edit.prevLogNumber)
+	}
+	if edit.hasNextFileNumber {
+		p = appendUvarint(p, tagNextFileNumber)
+		p = appendUvarint(p, edit.nextFileNumber)
+	}
+	if edit.hasLastSequence {
+		p = appendUvarint(p, tagLastSequence)
+		p = appendUvarint(p, edit.lastSequence)
+	}
+	for _, entry := range edit.compactPointers {
+		p = appendUvarint(p, tagCompactPointer)
+		p = appendUvarint(p, uint64(entry.level))
+		p = appendBytes(p, entry.key.Encode())
+	}
+	// c++版本的deleted files是std::set，按(level, number)排序并去重
+	deletedFiles := append([]deletedFileEntry(nil), edit.deletedFiles...)
+	sort.Slice(deletedFiles, func(i, j int) bool {
+		if deletedFiles[i].level != deletedFiles[j].level {
+			return deletedFiles[i].level < deletedFiles[j].level
+		}
+		return deletedFiles[i].number < deletedFiles[j].number
+	})
+	for i, entry := range deletedFiles {
+		if i > 0 && entry == deletedFiles[i-1] {
+			continue
+		}
+		p = appendUvarint(p, tagDeletedFile)
+		p = appendUvarint(p, uint64(entry.level))
+		p = appendUvarint(p, entry.number)
+	}
+	for _, entry := range edit.newFiles {
+		p = appendUvarint(p, tagNewFile)
+		p = appendUvarint(p, uint64(entry.level))
+		p = appendUvarint(p, entry.meta.number)
+		p = appendUvarint(p, entry.meta.fileSize)
+		p = appendBytes(p, entry.meta.smallest.Encode())
+		p = appendBytes(p, entry.meta.largest.Encode())
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+func (edit *VersionEdit) DecodeFrom(r io.Reader) error {
+	// 需要知道记录里还剩多少字节，先整条读到内存
+	br, ok := r.(*bytes.Reader)
+	if !ok {
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		br = bytes.NewReader(p)
+	}
+	d := editDecoder{r: br}
+	for {
+		tag, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return internal.ErrEditCorruption
+		}
+		switch tag {
+		case tagComparator:
+			edit.SetComparatorName(string(d.readBytes()))
+		case tagLogNumber:
+			edit.SetLogNumber(d.readUvarint())
+		case tagPrevLogNumber:
+			// 老版本c++的wal切换时使用，恢复时忽略
+			edit.SetPrevLogNumber(d.readUvarint())
+		case tagNextFileNumber:
+			edit.SetNextFile(d.readUvarint())
+		case tagLastSequence:
+			edit.SetLastSequence(d.readUvarint())
+		case tagCompactPointer:
+			level := d.readLevel()
+			key := d.readInternalKey()
+			if d.err == nil {
+				edit.SetCompactPointer(level, key)
+			}
+		case tagDeletedFile:
+			level := d.readLevel()
+			number := d.readUvarint()
+			edit.DeleteFile(level, number)
+		case tagNewFile:
+			level := d.readLevel()
+			number := d.readUvarint()
+			fileSize := d.readUvarint()
+			smallest := d.readInternalKey()
+			largest := d.readInternalKey()
+			if d.err == nil {
+				edit.AddFile(level, number, fileSize, smallest, largest)
+			}
+		default:
+			return internal.ErrEditCorruption
+		}
+		if d.err != nil {
+			return d.err
+		}
+	}
+	return nil
+}
+
+// 解析过程中出错后，后面的读取都直接返回零值，最后统一检查err
+type editDecoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *editDecoder) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = internal.ErrEditCorruption
+	}
+	return x
+}
+
+func (d *editDecoder) readLevel() int {
+	level := d.readUvarint()
+	if d.err == nil && level >= internal.NumLevels {
+		d.err = internal.ErrEditCorruption
+	}
+	return int(level)
+}
+
+func (d *editDecoder) readBytes() []byte {
+	n := d.readUvarint()
+	if d.err != nil {
+		return nil
+	}
+	// 长度被写坏时可能非常大，不能直接按它分配内存
+	if n > uint64(d.r.Len()) {
+		d.err = internal.ErrEditCorruption
+		return nil
+	}
+	p := make([]byte, n)
+	io.ReadFull(d.r, p)
+	return p
+}
+
+func (d *editDecoder) readInternalKey() *internal.InternalKey {
+	p := d.readBytes()
+	if d.err != nil {
+		return nil
+	}
+	key := internal.DecodeInternalKey(p)
+	if key == nil {
+		d.err = internal.ErrEditCorruption
+	}
+	return key
+}
+
+func appendUvarint(p []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(p, buf[:n]...)
+}
+
+func appendBytes(p []byte, b []byte) []byte {
+	p = appendUvarint(p, uint64(len(b)))
+	return append(p, b...)
+}
@@ -0,0 +1,49 @@
+package version
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/merlin82/leveldb/internal"
+)
+
+func Test_VersionEdit(t *testing.T) {
+	var edit VersionEdit
+	edit.SetComparatorName(internal.ComparatorName)
+	edit.SetLogNumber(100)
+	edit.SetNextFile(200)
+	edit.SetLastSequence(300)
+	smallest := internal.NewInternalKey(1, internal.TypeValue, []byte("123"), nil)
+	largest := internal.NewInternalKey(2, internal.TypeDeletion, []byte("125"), nil)
+	edit.SetCompactPointer(1, largest)
+	edit.DeleteFile(2, 7)
+	edit.AddFile(3, 8, 4096, smallest, largest)
+
+	var buf bytes.Buffer
+	if err := edit.EncodeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var edit2 VersionEdit
+	if err := edit2.DecodeFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(edit, edit2) {
+		t.Fatalf("%+v != %+v", edit, edit2)
+	}
+
+	// 截断的edit
+	var edit3 VersionEdit
+	if err := edit3.DecodeFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err != internal.ErrEditCorruption {
+		t.Fatal(err)
+	}
+}
+
+func Test_VersionEdit_BadLength(t *testing.T) {
+	// comparator的长度远大于记录剩下的字节数
+	p := []byte{tagComparator, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 'a'}
+	var edit VersionEdit
+	if err := edit.DecodeFrom(bytes.NewReader(p)); err != internal.ErrEditCorruption {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,353 @@
+package version
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/merlin82/leveldb/internal"
+	"github.com/merlin82/leveldb/log"
+	"github.com/merlin82/leveldb/sstable"
+)
+
+// 管理db当前的version，以及version之外需要持久化的元信息。
+// 每次修改都以VersionEdit的形式追加到MANIFEST，打开db时回放MANIFEST恢复。
+type VersionSet struct {
+	dbName             string
+	tableCache         *TableCache
+	dummyVersions      Version  // 链表头
+	current            *Version // == dummyVersions.prev
+	nextFileNumber     uint64
+	manifestFileNumber uint64
+	lastSequence       uint64 // lsn
+	logNumber          uint64 // 小于该编号的wal文件已经全部持久化到sstable
+	// Per-level key at which the next compaction at that level should start.
+	// Either an empty string, or a valid InternalKey.
+	compactPointer [internal.NumLevels]*internal.InternalKey
+
+	manifestFile *os.File
+	manifestLog  *log.Writer
+	manifestSize int64
+}
+
+// opts是读写sstable时的配置，可以为nil
+func NewVersionSet(dbName string, opts *sstable.Options) *VersionSet {
+	var vs VersionSet
+	vs.dbName = dbName
+	vs.tableCache = NewTableCache(dbName, opts)
+	vs.dummyVersions.next = &vs.dummyVersions
+	vs.dummyVersions.prev = &vs.dummyVersions
+	vs.appendVersion(newVersion(&vs))
+	vs.nextFileNumber = 1
+	return &vs
+}
+
+// v成为新的current，之前的current还有其他引用时会保留在链表中
+func (vs *VersionSet) appendVersion(v *Version) {
+	if vs.current != nil {
+		vs.current.Unref()
+	}
+	vs.current = v
+	v.Ref()
+
+	v.prev = vs.dummyVersions.prev
+	v.next = &vs.dummyVersions
+	v.prev.next = v
+	v.next.prev = v
+}
+
+func (vs *VersionSet) Current() *Version {
+	return vs.current
+}
+
+func (vs *VersionSet) NewFileNumber() uint64 {
+	number := vs.nextFileNumber
+	vs.nextFileNumber++
+	return number
+}
+
+// 恢复时发现已经使用过的文件号，保证以后不会重复分配
+func (vs *VersionSet) MarkFileNumberUsed(number uint64) {
+	if vs.nextFileNumber <= number {
+		vs.nextFileNumber = number + 1
+	}
+}
+
+func (vs *VersionSet) LastSequence() uint64 {
+	return vs.lastSequence
+}
+
+func (vs *VersionSet) SetLastSequence(seq uint64) {
+	vs.lastSequence = seq
+}
+
+func (vs *VersionSet) LogNumber() uint64 {
+	return vs.logNumber
+}
+
+func (vs *VersionSet) ManifestFileNumber() uint64 {
+	return vs.manifestFileNumber
+}
+
+func (vs *VersionSet) NumLevelFiles(level int) int {
+	return vs.current.NumLevelFiles(level)
+}
+
+// 是否有level需要合并
+func (vs *VersionSet) NeedsCompaction() bool {
+	return vs.current.pickCompactionLevel() >= 0
+}
+
+func (vs *VersionSet) TableCache() *TableCache {
+	return vs.tableCache
+}
+
+// 把所有还在使用的version引用的sstable文件号加到live中
+func (vs *VersionSet) AddLiveFiles(live map[uint64]bool) {
+	for v := vs.dummyVersions.next; v != &vs.dummyVersions; v = v.next {
+		for level := 0; level < internal.NumLevels; level++ {
+			for _, f := range v.files[level] {
+				live[f.number] = true
+			}
+		}
+	}
+}
+
+// edit追加到MANIFEST并sync，成功后生成新的current version
+// 第一次修改或者MANIFEST过大时，新建一个MANIFEST，先写入当前version的完整快照，再切换CURRENT
+func (vs *VersionSet) LogAndApply(edit *VersionEdit) error {
+	if !edit.hasLogNumber {
+		edit.SetLogNumber(vs.logNumber)
+	}
+	// 不使用prev log number，和c++版本一样总是写0
+	if !edit.hasPrevLogNumber {
+		edit.SetPrevLogNumber(0)
+	}
+	edit.SetLastSequence(vs.lastSequence)
+
+	v := vs.current.apply(edit)
+
+	newManifest := vs.manifestLog == nil || vs.manifestSize >= internal.MaxManifestFileSize
+	var manifestFileNumber uint64
+	if newManifest {
+		manifestFileNumber = vs.NewFileNumber()
+	}
+	edit.SetNextFile(vs.nextFileNumber)
+
+	file, w := vs.manifestFile, vs.manifestLog
+	if newManifest {
+		var err error
+		file, err = os.Create(internal.DescriptorFileName(vs.dbName, manifestFileNumber))
+		if err != nil {
+			return err
+		}
+		w = log.NewWriter(file)
+		if err := vs.writeSnapshot(w); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+	}
+
+	var record bytes.Buffer
+	err := edit.EncodeTo(&record)
+	if err == nil {
+		err = w.AddRecord(record.Bytes())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil && newManifest {
+		err = setCurrentFile(vs.dbName, manifestFileNumber)
+	}
+	if err != nil {
+		if newManifest {
+			file.Close()
+			os.Remove(file.Name())
+		}
+		return err
+	}
+
+	if newManifest {
+		if vs.manifestFile != nil {
+			vs.manifestFile.Close()
+		}
+		vs.manifestFile = file
+		vs.manifestLog = w
+		vs.manifestFileNumber = manifestFileNumber
+	}
+	if stat, err := file.Stat(); err == nil {
+		vs.manifestSize = stat.Size()
+	}
+
+	vs.appendVersion(v)
+	vs.logNumber = edit.logNumber
+	for _, entry := range edit.compactPointers {
+		vs.compactPointer[entry.level] = entry.key
+	}
+	return nil
+}
+
+// 新MANIFEST的第一条记录：当前version的全部文件和compact pointer
+func (vs *VersionSet) writeSnapshot(w *log.Writer) error {
+	var edit VersionEdit
+	edit.SetComparatorName(internal.ComparatorName)
+	for level := 0; level < internal.NumLevels; level++ {
+		if vs.compactPointer[level] != nil {
+			edit.SetCompactPointer(level, vs.compactPointer[level])
+		}
+	}
+	for level := 0; level < internal.NumLevels; level++ {
+		for _, f := range vs.current.files[level] {
+			edit.AddFile(level, f.number, f.fileSize, f.smallest, f.largest)
+		}
+	}
+	var record bytes.Buffer
+	if err := edit.EncodeTo(&record); err != nil {
+		return err
+	}
+	return w.AddRecord(record.Bytes())
+}
+
+// 打开db时调用，根据CURRENT找到MANIFEST，回放里面所有的edit
+// CURRENT不存在时是一个新的db
+func (vs *VersionSet) Recover() error {
+	manifestFileNumber, legacy, err := readCurrentFile(vs.dbName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if legacy {
+		return vs.recoverLegacy(manifestFileNumber)
+	}
+	fileName := internal.DescriptorFileName(vs.dbName, manifestFileNumber)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var hasLogNumber, hasNextFileNumber, hasLastSequence bool
+	var logNumber, nextFileNumber, lastSequence uint64
+	v := newVersion(vs)
+	torn := false
+	reader := log.NewReader(file)
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err == log.ErrTornTail {
+			// 写MANIFEST时崩溃，这条edit没有生效
+			torn = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var edit VersionEdit
+		if err := edit.DecodeFrom(bytes.NewReader(record)); err != nil {
+			return err
+		}
+		if edit.hasComparator && edit.comparator != internal.ComparatorName {
+			return fmt.Errorf("%s does not match existing comparator %s", internal.ComparatorName, edit.comparator)
+		}
+		v = v.apply(&edit)
+		for _, entry := range edit.compactPointers {
+			vs.compactPointer[entry.level] = entry.key
+		}
+		if edit.hasLogNumber {
+			hasLogNumber = true
+			logNumber = edit.logNumber
+		}
+		if edit.hasNextFileNumber {
+			hasNextFileNumber = true
+			nextFileNumber = edit.nextFileNumber
+		}
+		if edit.hasLastSequence {
+			hasLastSequence = true
+			lastSequence = edit.lastSequence
+		}
+	}
+	if !hasLogNumber || !hasNextFileNumber || !hasLastSequence {
+		return internal.ErrEditCorruption
+	}
+
+	vs.appendVersion(v)
+	vs.nextFileNumber = nextFileNumber
+	vs.MarkFileNumberUsed(logNumber)
+	vs.MarkFileNumberUsed(manifestFileNumber)
+	vs.logNumber = logNumber
+	vs.lastSequence = lastSequence
+	vs.manifestFileNumber = manifestFileNumber
+
+	// MANIFEST不大，并且结尾完整，后面的修改继续追加到这个文件
+	if !torn {
+		vs.reuseManifest(fileName)
+	}
+	return nil
+}
+
+func (vs *VersionSet) reuseManifest(fileName string) {
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.Size() >= internal.MaxManifestFileSize {
+		return
+	}
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	vs.manifestFile = file
+	vs.manifestLog = log.NewWriterAt(file, stat.Size())
+	vs.manifestSize = stat.Size()
+}
+
+func (vs *VersionSet) Close() error {
+	vs.tableCache.Close()
+	if vs.manifestFile == nil {
+		return nil
+	}
+	err := vs.manifestFile.Close()
+	vs.manifestFile = nil
+	vs.manifestLog = nil
+	return err
+}
+
+//更新current文件里面的值，为了保证原子操作，此处用mv来实现
+// 内容和c++版本一致，是MANIFEST的文件名加换行：MANIFEST-000005\n
+func setCurrentFile(dbName string, descriptorNumber uint64) error {
+	tmp := internal.TempFileName(dbName, descriptorNumber)
+	contents := filepath.Base(internal.DescriptorFileName(dbName, descriptorNumber)) + "\n"
+	if err := ioutil.WriteFile(tmp, []byte(contents), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, internal.CurrentFileName(dbName)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// 以前的版本只写了文件号，对应的MANIFEST是整个version的快照，不是VersionEdit记录，这时legacy为true
+func readCurrentFile(dbName string) (descriptorNumber uint64, legacy bool, err error) {
+	b, err := ioutil.ReadFile(internal.CurrentFileName(dbName))
+	if err != nil {
+		return 0, false, err
+	}
+	descriptorNumber, fileType, ok := internal.ParseFileName(strings.TrimSpace(string(b)))
+	if ok && fileType == internal.DescriptorFile {
+		return descriptorNumber, false, nil
+	}
+	if descriptorNumber, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err == nil {
+		return descriptorNumber, true, nil
+	}
+	return 0, false, internal.ErrEditCorruption
+}
@@ -1,35 +1,227 @@
 package version
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
 	"testing"
 
 	"github.com/merlin82/leveldb/internal"
 	"github.com/merlin82/leveldb/memtable"
 )
 
+func tempDbName(t *testing.T) string {
+	dbName, err := ioutil.TempDir("", "leveldbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbName
+}
+
 func Test_Version_Get(t *testing.T) {
-	v := New("D:\\")
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	v := newVersion(NewVersionSet(dbName, nil))
 	var f FileMetaData
 	f.number = 123
 	f.smallest = internal.NewInternalKey(1, internal.TypeValue, []byte("123"), nil)
 	f.largest = internal.NewInternalKey(1, internal.TypeValue, []byte("125"), nil)
 	v.files[0] = append(v.files[0], &f)
 
-	value, err := v.Get([]byte("125"))
+	value, err := v.Get(internal.LookupKey([]byte("125"), math.MaxUint64), nil)
 	fmt.Println(err, value)
 }
 
-func Test_Version_Load(t *testing.T) {
-	v := New("D:\\leveldbtest")
+func Test_VersionSet_Recover(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	if err := vs.Recover(); err != nil {
+		t.Fatal(err)
+	}
 	memTable := memtable.New()
 	memTable.Add(1234567, internal.TypeValue, []byte("aadsa34a"), []byte("bb23b3423"))
-	v.WriteLevel0Table(memTable)
-	n, _ := v.Save()
-	fmt.Println(v)
+	var edit VersionEdit
+	if err := vs.Current().WriteLevel0Table(memTable, vs.NewFileNumber(), &edit, internal.MaxMemCompactLevel); err != nil {
+		t.Fatal(err)
+	}
+	edit.SetLogNumber(5)
+	vs.SetLastSequence(1234567)
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	// 第二次修改追加到同一个MANIFEST
+	var edit2 VersionEdit
+	edit2.SetLogNumber(6)
+	if err := vs.LogAndApply(&edit2); err != nil {
+		t.Fatal(err)
+	}
+	manifestFileNumber := vs.ManifestFileNumber()
+	vs.Close()
 
-	v2, _ := Load("D:\\leveldbtest", n)
-	fmt.Println(v2)
-	value, err := v2.Get([]byte("aadsa34a"))
-	fmt.Println(err, value)
+	vs2 := NewVersionSet(dbName, nil)
+	if err := vs2.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer vs2.Close()
+	if vs2.LogNumber() != 6 || vs2.LastSequence() != 1234567 || vs2.ManifestFileNumber() != manifestFileNumber {
+		t.Fatal(vs2.LogNumber(), vs2.LastSequence(), vs2.ManifestFileNumber())
+	}
+	if vs2.NewFileNumber() <= manifestFileNumber {
+		t.Fatal("file number reused")
+	}
+	value, err := vs2.Current().Get(internal.LookupKey([]byte("aadsa34a"), math.MaxUint64), nil)
+	if err != nil || string(value) != "bb23b3423" {
+		t.Fatal(err, string(value))
+	}
+}
+
+// 按以前的版本写MANIFEST快照和CURRENT
+func writeLegacyManifest(t *testing.T, dbName string, number, nextFileNumber, seq uint64, files [internal.NumLevels][]*FileMetaData) {
+	var buf bytes.Buffer
+	write := func(data interface{}) {
+		binary.Write(&buf, binary.LittleEndian, data)
+	}
+	writeKey := func(key *internal.InternalKey) {
+		write(key.Seq)
+		write(key.Type)
+		write(int32(len(key.UserKey)))
+		write(key.UserKey)
+		write(int32(len(key.UserValue)))
+		write(key.UserValue)
+	}
+	write(nextFileNumber)
+	write(seq)
+	for level := 0; level < internal.NumLevels; level++ {
+		write(int32(len(files[level])))
+		for _, f := range files[level] {
+			write(f.allowSeeks)
+			write(f.fileSize)
+			write(f.number)
+			writeKey(f.smallest)
+			writeKey(f.largest)
+		}
+	}
+	if err := ioutil.WriteFile(internal.DescriptorFileName(dbName, number), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(internal.CurrentFileName(dbName), []byte(fmt.Sprintf("%d", number)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_VersionSet_LegacyManifest(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	var files [internal.NumLevels][]*FileMetaData
+	files[0] = []*FileMetaData{{allowSeeks: 10, number: 5, fileSize: 100,
+		smallest: internal.NewInternalKey(30, internal.TypeValue, []byte("a"), []byte("1")),
+		largest:  internal.NewInternalKey(31, internal.TypeDeletion, []byte("c"), nil)}}
+	files[2] = []*FileMetaData{{allowSeeks: 10, number: 3, fileSize: 200,
+		smallest: internal.NewInternalKey(1, internal.TypeValue, []byte("b"), []byte("2")),
+		largest:  internal.NewInternalKey(2, internal.TypeValue, []byte("d"), []byte("3"))}}
+	writeLegacyManifest(t, dbName, 7, 8, 40, files)
+
+	check := func(vs *VersionSet) {
+		if vs.LastSequence() != 40 || vs.NumLevelFiles(0) != 1 || vs.NumLevelFiles(2) != 1 {
+			t.Fatalf("last sequence %d, files %d %d", vs.LastSequence(), vs.NumLevelFiles(0), vs.NumLevelFiles(2))
+		}
+		f := vs.Current().files[2][0]
+		if f.number != 3 || f.fileSize != 200 || string(f.smallest.UserKey) != "b" || f.largest.Seq != 2 {
+			t.Fatalf("file %d, size %d, %s@%d", f.number, f.fileSize, f.smallest.UserKey, f.largest.Seq)
+		}
+		if vs.ManifestFileNumber() <= 7 {
+			t.Fatalf("manifest %d", vs.ManifestFileNumber())
+		}
+	}
+	vs := NewVersionSet(dbName, nil)
+	if err := vs.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	check(vs)
+	vs.Close()
+	// CURRENT已经指向新的MANIFEST
+	if _, legacy, err := readCurrentFile(dbName); legacy || err != nil {
+		t.Fatalf("readCurrentFile = %v, %v", legacy, err)
+	}
+	vs = NewVersionSet(dbName, nil)
+	if err := vs.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer vs.Close()
+	check(vs)
+
+	// 快照被截断
+	p, _ := ioutil.ReadFile(internal.DescriptorFileName(dbName, 7))
+	ioutil.WriteFile(internal.DescriptorFileName(dbName, 7), p[:len(p)-1], 0644)
+	ioutil.WriteFile(internal.CurrentFileName(dbName), []byte("7"), 0600)
+	if err := NewVersionSet(dbName, nil).Recover(); err != internal.ErrEditCorruption {
+		t.Fatalf("Recover = %v, want %v", err, internal.ErrEditCorruption)
+	}
+}
+
+func Test_Version_WriteLevel0TableError(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	if err := vs.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	defer vs.Close()
+	memTable := memtable.New()
+	memTable.Add(1, internal.TypeValue, []byte("a"), []byte("1"))
+
+	// sstable的路径是目录，创建文件失败
+	number := vs.NewFileNumber()
+	if err := os.Mkdir(internal.TableFileName(dbName, number), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var edit VersionEdit
+	if err := vs.Current().WriteLevel0Table(memTable, number, &edit, internal.MaxMemCompactLevel); err == nil {
+		t.Fatal("WriteLevel0Table succeeded")
+	}
+	if len(edit.newFiles) != 0 {
+		t.Fatal(edit.newFiles)
+	}
+}
+
+func Test_VersionSet_Ref(t *testing.T) {
+	dbName := tempDbName(t)
+	defer os.RemoveAll(dbName)
+	vs := NewVersionSet(dbName, nil)
+	defer vs.Close()
+	smallest := internal.NewInternalKey(1, internal.TypeValue, []byte("123"), nil)
+	largest := internal.NewInternalKey(2, internal.TypeValue, []byte("125"), nil)
+
+	var edit VersionEdit
+	edit.AddFile(0, 10, 100, smallest, largest)
+	if err := vs.LogAndApply(&edit); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟读请求引用住当前的version
+	v := vs.Current()
+	v.Ref()
+
+	var edit2 VersionEdit
+	edit2.DeleteFile(0, 10)
+	edit2.AddFile(1, 11, 100, smallest, largest)
+	if err := vs.LogAndApply(&edit2); err != nil {
+		t.Fatal(err)
+	}
+
+	live := make(map[uint64]bool)
+	vs.AddLiveFiles(live)
+	if !live[10] || !live[11] {
+		t.Fatal(live)
+	}
+
+	v.Unref()
+	live = make(map[uint64]bool)
+	vs.AddLiveFiles(live)
+	if live[10] || !live[11] {
+		t.Fatal(live)
+	}
 }